@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// buildWAV wraps the samples of a PCM sound in a RIFF/WAVE container whose
+// fmt chunk is the wave format stored in the Sound_DX8 header
+func buildWAV(sound *wz.WZSoundDX8) []byte {
+	fmtChunk := sound.WaveFormat
+	if len(fmtChunk) > 18 {
+		// PCM has no extra format bytes
+		fmtChunk = fmtChunk[:18]
+	}
+	data := sound.SoundData
+
+	padding := len(data) % 2
+	size := 4 + 8 + len(fmtChunk) + 8 + len(data) + padding
+	out := make([]byte, 0, 8+size)
+
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	out = append(out, "WAVE"...)
+
+	out = append(out, "fmt "...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(fmtChunk)))
+	out = append(out, fmtChunk...)
+
+	out = append(out, "data"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if padding != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// Mismatch is a single difference between a WZ source and its NX output
+type Mismatch struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// compareOptions controls what the comparison expects of the NX output
+type compareOptions struct {
+	// Server expects canvases and sounds to have been converted without
+	// bitmap or audio data
+	Server bool
+	// Metadata ignores the reserved metadata children (_format, _playtime,
+	// ...) that the converter attaches to canvases and sounds
+	Metadata bool
+	// UOLs is the policy the NX file was converted with. Dropped UOLs are
+	// expected as empty nodes and resolved UOLs, which hold a copy of
+	// their target, are not compared.
+	UOLs converter.UOLPolicy
+}
+
+// comparer walks a WZ tree and an NX tree side by side
+type comparer struct {
+	opts       compareOptions
+	mismatches []Mismatch
+}
+
+// compareFiles checks that an NX file holds exactly the data of a WZ file
+func compareFiles(wzFilename, nxFilename string, opts compareOptions) ([]Mismatch, error) {
+	wzFile, err := converter.OpenWZFile(wzFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer wzFile.Close()
+
+	nxFile, err := nx.Open(nxFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer nxFile.Close()
+
+	cmp := &comparer{opts: opts}
+	if wzFile.Root != nil {
+		cmp.compareDirectory(wzFile.Root, nxFile.Root(), "")
+	}
+	return cmp.mismatches, nil
+}
+
+// addf records a mismatch at the given path
+func (cmp *comparer) addf(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+	cmp.mismatches = append(cmp.mismatches, Mismatch{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// joinPath appends a node name to a path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
+
+// compareChildren matches expected child names against the NX children in
+// order and returns the pairs that line up by position
+func (cmp *comparer) compareChildren(path string, names []string, node nx.Node) []nx.Node {
+	children := node.Children()
+	if cmp.opts.Metadata || cmp.opts.Server {
+		kept := children[:0:0]
+		for _, child := range children {
+			if !cmp.ignored(child.Name()) {
+				kept = append(kept, child)
+			}
+		}
+		children = kept
+	}
+	if len(children) != len(names) {
+		cmp.addf(path, "child count: WZ has %d, NX has %d", len(names), len(children))
+	}
+	for i, child := range children {
+		if i >= len(names) {
+			cmp.addf(joinPath(path, child.Name()), "unexpected node in NX")
+			continue
+		}
+		if child.Name() != names[i] {
+			cmp.addf(joinPath(path, names[i]), "child %d: NX node is named %q", i, child.Name())
+		}
+	}
+	for i := len(children); i < len(names); i++ {
+		cmp.addf(joinPath(path, names[i]), "missing from NX")
+	}
+	if len(children) > len(names) {
+		children = children[:len(names)]
+	}
+	return children
+}
+
+// ignored reports whether an NX child is reserved metadata that the
+// comparison skips. Server mode canvas sizes are checked by compareCanvas.
+func (cmp *comparer) ignored(name string) bool {
+	if cmp.opts.Server && (name == converter.MetadataWidth || name == converter.MetadataHeight) {
+		return true
+	}
+	return cmp.opts.Metadata && converter.IsMetadata(name)
+}
+
+// expectType records a mismatch unless the NX node has the wanted type
+func (cmp *comparer) expectType(path string, node nx.Node, want nx.NodeType) bool {
+	if got := node.Type(); got != want {
+		cmp.addf(path, "type: want %s, NX has %s", want, got)
+		return false
+	}
+	return true
+}
+
+// compareDirectory compares a WZ directory with its NX node
+func (cmp *comparer) compareDirectory(dir *wz.WZDirectory, node nx.Node, path string) {
+	cmp.expectType(path, node, nx.TypeNone)
+
+	names := make([]string, 0, len(dir.Directories)+len(dir.Images))
+	for _, sub := range dir.Directories {
+		names = append(names, sub.Name)
+	}
+	for _, img := range dir.Images {
+		names = append(names, img.Name)
+	}
+	children := cmp.compareChildren(path, names, node)
+
+	for i, child := range children {
+		childPath := joinPath(path, names[i])
+		if i < len(dir.Directories) {
+			cmp.compareDirectory(dir.Directories[i], child, childPath)
+		} else {
+			cmp.compareImage(dir.Images[i-len(dir.Directories)], child, childPath)
+		}
+	}
+}
+
+// compareImage parses a WZ image and compares it with its NX node
+func (cmp *comparer) compareImage(img *wz.WZImage, node nx.Node, path string) {
+	defer func() {
+		if r := recover(); r != nil {
+			cmp.addf(path, "parsing WZ image: %v", r)
+		}
+	}()
+	img.ParseWithCopy()
+
+	cmp.expectType(path, node, nx.TypeNone)
+	cmp.compareProperty(img.Properties, node, path)
+}
+
+// compareProperty compares the children of a property list
+func (cmp *comparer) compareProperty(prop *wz.WZProperty, node nx.Node, path string) {
+	var names []string
+	if prop != nil {
+		for _, variant := range prop.Entries {
+			names = append(names, variant.Name)
+		}
+	}
+	children := cmp.compareChildren(path, names, node)
+	for i, child := range children {
+		cmp.compareVariant(prop.Entries[i], child, joinPath(path, names[i]))
+	}
+}
+
+// compareVariant compares a WZ property value with its NX node. Integers
+// may widen to int64 and float32 values may widen to double.
+func (cmp *comparer) compareVariant(variant *wz.WZVariant, node nx.Node, path string) {
+	if variant.Type == 9 {
+		cmp.compareObject(variant.Value, node, path)
+		return
+	}
+
+	// Only sub objects have children
+	cmp.compareChildren(path, nil, node)
+
+	switch value := variant.Value.(type) {
+	case nil:
+		cmp.expectType(path, node, nx.TypeNone)
+	case int16:
+		cmp.compareInt(int64(value), node, path)
+	case int32:
+		cmp.compareInt(int64(value), node, path)
+	case int64:
+		cmp.compareInt(value, node, path)
+	case float32:
+		cmp.compareDouble(float64(value), node, path)
+	case float64:
+		cmp.compareDouble(value, node, path)
+	case string:
+		cmp.compareString(value, node, path)
+	default:
+		cmp.addf(path, "unsupported WZ value type %d (%T)", variant.Type, value)
+	}
+}
+
+// compareInt compares an integer value
+func (cmp *comparer) compareInt(want int64, node nx.Node, path string) {
+	if !cmp.expectType(path, node, nx.TypeInt64) {
+		return
+	}
+	if got, _ := node.Int(); got != want {
+		cmp.addf(path, "value: WZ has %d, NX has %d", want, got)
+	}
+}
+
+// compareDouble compares a floating point value
+func (cmp *comparer) compareDouble(want float64, node nx.Node, path string) {
+	if !cmp.expectType(path, node, nx.TypeDouble) {
+		return
+	}
+	if got, _ := node.Double(); got != want {
+		cmp.addf(path, "value: WZ has %v, NX has %v", want, got)
+	}
+}
+
+// compareString compares a string value
+func (cmp *comparer) compareString(want string, node nx.Node, path string) {
+	if !cmp.expectType(path, node, nx.TypeString) {
+		return
+	}
+	if got, _ := node.Str(); got != want {
+		cmp.addf(path, "value: WZ has %q, NX has %q", want, got)
+	}
+}
+
+// compareObject compares a WZ sub object (canvas, vector, sound, property,
+// UOL or convex shape) with its NX node
+func (cmp *comparer) compareObject(obj interface{}, node nx.Node, path string) {
+	switch v := obj.(type) {
+	case *wz.WZProperty:
+		cmp.expectType(path, node, nx.TypeNone)
+		cmp.compareProperty(v, node, path)
+
+	case *wz.WZCanvas:
+		cmp.compareProperty(v.Properties, node, path)
+		cmp.compareCanvas(v, node, path)
+
+	case *wz.WZVector:
+		cmp.compareChildren(path, nil, node)
+		if !cmp.expectType(path, node, nx.TypePoint) {
+			return
+		}
+		if x, y, _ := node.Point(); x != v.X || y != v.Y {
+			cmp.addf(path, "value: WZ has (%d, %d), NX has (%d, %d)", v.X, v.Y, x, y)
+		}
+
+	case *wz.WZSoundDX8:
+		cmp.compareChildren(path, nil, node)
+		cmp.compareSound(v, node, path)
+
+	case *wz.WZUOL:
+		switch cmp.opts.UOLs {
+		case converter.UOLDrop:
+			cmp.compareChildren(path, nil, node)
+			cmp.expectType(path, node, nx.TypeNone)
+		case converter.UOLString:
+			cmp.compareChildren(path, nil, node)
+			cmp.compareString(v.Reference, node, path)
+		}
+
+	case []interface{}:
+		// The converter writes Convex2D shapes as empty nodes
+		cmp.expectType(path, node, nx.TypeNone)
+		if node.ChildCount() == 0 {
+			return
+		}
+		names := make([]string, len(v))
+		for i := range v {
+			names[i] = fmt.Sprint(i)
+		}
+		children := cmp.compareChildren(path, names, node)
+		for i, child := range children {
+			cmp.compareObject(v[i], child, joinPath(path, names[i]))
+		}
+
+	default:
+		cmp.addf(path, "unsupported WZ object %T", obj)
+	}
+}
+
+// compareCanvas compares canvas dimensions and decoded pixels
+func (cmp *comparer) compareCanvas(canvas *wz.WZCanvas, node nx.Node, path string) {
+	if cmp.opts.Server {
+		cmp.expectType(path, node, nx.TypeNone)
+		cmp.compareDimension(canvas.Width, node, path, converter.MetadataWidth)
+		cmp.compareDimension(canvas.Height, node, path, converter.MetadataHeight)
+		return
+	}
+	if canvas.Width <= 0 || canvas.Height <= 0 {
+		cmp.expectType(path, node, nx.TypeNone)
+		return
+	}
+	if !cmp.expectType(path, node, nx.TypeBitmap) {
+		return
+	}
+
+	bitmap, _ := node.Bitmap()
+	if int32(bitmap.Width) != canvas.Width || int32(bitmap.Height) != canvas.Height {
+		cmp.addf(path, "size: WZ is %dx%d, NX is %dx%d", canvas.Width, canvas.Height, bitmap.Width, bitmap.Height)
+		return
+	}
+
+	want, err := converter.CanvasPixels(canvas)
+	if err != nil {
+		cmp.addf(path, "decoding WZ canvas: %v", err)
+		return
+	}
+	got, err := node.Pixels()
+	if err != nil {
+		cmp.addf(path, "decoding NX bitmap: %v", err)
+		return
+	}
+	if !bytes.Equal(want, got) {
+		cmp.addf(path, "pixels differ (%d of %d bytes)", countDifferentBytes(want, got), len(want))
+	}
+}
+
+// compareDimension checks a canvas size kept as a child node in server
+// mode, if the node has one
+func (cmp *comparer) compareDimension(want int32, node nx.Node, path string, name string) {
+	child, ok := node.Child(name)
+	if !ok {
+		return
+	}
+	if got, _ := child.Int(); child.Type() != nx.TypeInt64 || got != int64(want) {
+		cmp.addf(joinPath(path, name), "WZ canvas size is %d, NX has %d", want, got)
+	}
+}
+
+// compareSound compares a sound payload with its NX audio entry
+func (cmp *comparer) compareSound(sound *wz.WZSoundDX8, node nx.Node, path string) {
+	if cmp.opts.Server {
+		cmp.expectType(path, node, nx.TypeNone)
+		return
+	}
+	if !cmp.expectType(path, node, nx.TypeAudio) {
+		return
+	}
+	got, err := node.AudioData()
+	if err != nil {
+		cmp.addf(path, "reading NX audio: %v", err)
+		return
+	}
+	if !bytes.Equal(sound.SoundData, got) {
+		cmp.addf(path, "audio differs: WZ has %d bytes, NX has %d", len(sound.SoundData), len(got))
+	}
+}
+
+// countDifferentBytes counts positions at which a and b differ, treating
+// the length difference as differing bytes
+func countDifferentBytes(a, b []byte) int {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	diff := 0
+	for i := 0; i < n; i++ {
+		if i >= len(a) || i >= len(b) || a[i] != b[i] {
+			diff++
+		}
+	}
+	return diff
+}
+
+// runDiff implements the diff command, formerly compare, and returns the
+// process exit code: 0 when the files match, 1 on mismatches and 2 on
+// errors
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	server := flags.Bool("server", false, "Expect server mode output (no bitmaps or audio)")
+	metadata := flags.Bool("metadata", false, "Ignore metadata children written with --metadata")
+	uol := flags.String("uol", "drop", "UOL policy the NX file was converted with: drop, string or resolve (not compared)")
+	asJSON := flags.Bool("json", false, "Print mismatches as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go-wztonx-converter diff [options] <file.wz> <file.nx>")
+		fmt.Fprintln(flags.Output(), "Checks that an NX file holds exactly the data of its WZ source.")
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	uols, err := converter.ParseUOLPolicy(*uol)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --uol: %v\n", err)
+		return 2
+	}
+
+	mismatches, err := compareFiles(flags.Arg(0), flags.Arg(1), compareOptions{Server: *server, Metadata: *metadata, UOLs: uols})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if mismatches == nil {
+			mismatches = []Mismatch{}
+		}
+		if err := encoder.Encode(mismatches); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+			return 2
+		}
+	} else {
+		for _, m := range mismatches {
+			fmt.Printf("%s: %s\n", m.Path, m.Message)
+		}
+		fmt.Printf("%d mismatch(es)\n", len(mismatches))
+	}
+
+	if len(mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
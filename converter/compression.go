@@ -1,4 +1,4 @@
-package main
+package converter
 
 import (
 	"bytes"
@@ -46,9 +46,9 @@ func compressLZ4HC(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Compress data based on HC flag
+// Compress data at the configured level
 func (c *Converter) compressData(data []byte) ([]byte, error) {
-	if c.hc {
+	if c.opts.Compression == LZ4HC {
 		return compressLZ4HC(data)
 	}
 	return compressLZ4(data)
@@ -0,0 +1,1163 @@
+package converter
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// NX file format constants
+const (
+	NXMagic      = "PKG4"
+	BufferSizeMB = 4 // 4MB buffer for improved write performance
+
+	nxHeaderSize = 52 // magic, then the count and offset of each section
+	nxNodeSize   = 20
+)
+
+// Node types
+const (
+	NodeTypeNone   = 0
+	NodeTypeInt64  = 1
+	NodeTypeDouble = 2
+	NodeTypeString = 3
+	NodeTypePOINT  = 4 // Vector (x, y)
+	NodeTypeBitmap = 5
+	NodeTypeAudio  = 6
+)
+
+// Converter handles the conversion from WZ to NX format
+type Converter struct {
+	wzFilename string
+	nxFilename string
+	opts       Options
+	// ctx cancels the conversion. Workers check it between images,
+	// bitmaps and buffer flushes.
+	ctx context.Context
+	// cancel cancels ctx with the first failure under FailFast
+	cancel context.CancelCauseFunc
+	// client is the parse mode: true when an output needs bitmaps and audio
+	client bool
+	// mergeInputs lists the WZ files merged into one output. Each is
+	// mounted under a top-level node named after the file.
+	mergeInputs []string
+	// input, if set, is read instead of the file wzFilename
+	input *readerInput
+	// nxWriter, if set, receives the output instead of the file nxFilename
+	nxWriter io.Writer
+
+	// NX data structures
+	nodes     []*Node
+	strings   []string
+	stringMap map[string]uint32
+	bitmaps   []BitmapData
+	audio     []AudioData
+	mu        sync.Mutex // guards bitmaps, audio, dedup state, uols, failures and warnings during parallel traversal
+
+	// Content-hash deduplication of bitmaps and audio
+	bitmapIDs  map[bitmapKey]uint32
+	audioIDs   map[[sha256.Size]byte]uint32
+	dedupStats DedupStats
+
+	// treeNodes counts the nodes of the written tree before subtree sharing
+	treeNodes int
+
+	// uols holds the UOL nodes waiting to be resolved, by node
+	uols     map[*Node]*uolRef
+	failures []Failure
+	warnings []string
+
+	// Progress reporting
+	progressMu  sync.Mutex
+	lastStage   Stage
+	lastPercent int64
+	imagesDone  atomic.Int64
+	imagesTotal int
+
+	// log receives the records of the conversion, with the input and
+	// output files attached
+	log *slog.Logger
+}
+
+// Node represents a node in the NX file
+type Node struct {
+	Name     string
+	Children []*Node
+	Type     uint16
+	Data     interface{}
+
+	// firstChild is the index of the first child in the node table, set
+	// by flattenNodes
+	firstChild uint32
+}
+
+// BitmapNodeData stores bitmap node information
+type BitmapNodeData struct {
+	ID     uint32
+	Width  uint16
+	Height uint16
+}
+
+// AudioNodeData stores audio node information
+type AudioNodeData struct {
+	ID     uint32
+	Length uint32
+}
+
+// BitmapData stores bitmap information
+type BitmapData struct {
+	Width          uint16
+	Height         uint16
+	Data           []byte
+	CompressedData []byte
+	Offset         uint64
+}
+
+// AudioData stores audio information
+type AudioData struct {
+	Length         uint32
+	Data           []byte
+	CompressedData []byte
+	Offset         uint64
+}
+
+// bitmapKey identifies bitmap content for deduplication
+type bitmapKey struct {
+	hash          [sha256.Size]byte
+	width, height uint16
+}
+
+// readerInput is a WZ file read through io.ReaderAt
+type readerInput struct {
+	r    io.ReaderAt
+	size int64
+	name string
+}
+
+// New creates a converter that converts wzFile to nxFile
+func New(wzFile, nxFile string, opts Options) *Converter {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(discardHandler{})
+	}
+	if wzFile != "" {
+		logger = logger.With("input", wzFile)
+	}
+	return &Converter{
+		log:        logger,
+		wzFilename: wzFile,
+		nxFilename: nxFile,
+		opts:       opts,
+		ctx:        context.Background(),
+		client:     opts.Mode == Client,
+		stringMap:  make(map[string]uint32),
+		bitmapIDs:  make(map[bitmapKey]uint32),
+		audioIDs:   make(map[[sha256.Size]byte]uint32),
+	}
+}
+
+// NewReader creates a converter that reads a WZ file of size bytes from r
+// instead of mapping a file from disk. name identifies the input in
+// messages. Outputs go to nxFile unless opts.Outputs says otherwise. The
+// parser issues many small reads at scattered offsets, so slow sources
+// should be buffered or cached by the caller.
+func NewReader(r io.ReaderAt, size int64, name, nxFile string, opts Options) *Converter {
+	c := New(name, nxFile, opts)
+	c.input = &readerInput{r: r, size: size, name: name}
+	return c
+}
+
+// NewMerge creates a converter that merges several WZ files into one NX
+// file, mounting each under a top-level node named after the file (Map.wz
+// under "Map"). Strings, bitmaps and audio share one set of tables.
+func NewMerge(wzFiles []string, nxFile string, opts Options) *Converter {
+	c := New("", nxFile, opts)
+	c.mergeInputs = wzFiles
+	return c
+}
+
+// fail records a part of the input that could not be converted and, under
+// FailFast, cancels the conversion with it
+func (c *Converter) fail(path string, err error) {
+	failure := Failure{Path: path, Err: err}
+	c.mu.Lock()
+	c.failures = append(c.failures, failure)
+	c.mu.Unlock()
+	c.log.Error("conversion of node failed", "path", path, "error", err)
+	if c.opts.Errors == FailFast && c.cancel != nil {
+		c.cancel(failure)
+	}
+}
+
+// withCancel makes the context of the conversion cancellable by fail. The
+// returned function releases it and replaces err by the failure that
+// stopped the conversion, if any.
+func (c *Converter) withCancel(ctx context.Context) func(err error) error {
+	c.ctx, c.cancel = context.WithCancelCause(ctx)
+	return func(err error) error {
+		var failure Failure
+		if err != nil && errors.As(context.Cause(c.ctx), &failure) {
+			err = failure
+		}
+		c.cancel(nil)
+		return err
+	}
+}
+
+// warn records a recoverable problem as a warning of the result and logs
+// it with its attributes
+func (c *Converter) warn(msg string, args ...any) {
+	record := msg
+	for i := 0; i+1 < len(args); i += 2 {
+		record += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	c.mu.Lock()
+	c.warnings = append(c.warnings, record)
+	c.mu.Unlock()
+	c.log.Warn(msg, args...)
+}
+
+// progress reports an event of a stage to the progress hook. Progress
+// events are dropped unless they advance the stage by at least a percent,
+// which also drops events of parallel workers that arrive out of order.
+func (c *Converter) progress(event Event, stage Stage, output string, done, total int64) {
+	if c.opts.Progress == nil {
+		return
+	}
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	switch event {
+	case EventStart:
+		c.lastStage, c.lastPercent = stage, 0
+	case EventProgress:
+		var percent int64 = 100
+		if total > 0 {
+			percent = done * 100 / total
+		}
+		if stage == c.lastStage && percent <= c.lastPercent {
+			return
+		}
+		c.lastStage, c.lastPercent = stage, percent
+	}
+	c.opts.Progress(Progress{Event: event, Stage: stage, Output: output, Done: done, Total: total})
+}
+
+// workers returns the number of images parsed or bitmaps compressed at once
+func (c *Converter) workers() int {
+	if c.opts.Workers > 0 {
+		return c.opts.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// newRootNode creates the unnamed root of a node tree
+func newRootNode() *Node {
+	return &Node{Name: "", Children: []*Node{}, Type: NodeTypeNone}
+}
+
+// Convert parses the input once and writes every output
+func (c *Converter) Convert() (*Result, error) {
+	return c.ConvertContext(context.Background())
+}
+
+// ConvertContext is Convert with a context. When ctx is cancelled, parsing,
+// compression and writing stop promptly and the context error is
+// returned. Output files are written under a temporary name and renamed
+// into place only once complete, so a cancelled or failed conversion
+// leaves no partial NX file behind. Under FailFast, the first failure is
+// returned as a Failure.
+func (c *Converter) ConvertContext(ctx context.Context) (*Result, error) {
+	done := c.withCancel(ctx)
+	result, err := c.convert()
+	if err = done(err); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// convert parses the input and writes every output
+func (c *Converter) convert() (*Result, error) {
+	outputs := c.opts.Outputs
+	if len(outputs) == 0 {
+		outputs = []Output{{Filename: c.nxFilename, Mode: c.opts.Mode}}
+	}
+
+	// The input is parsed once, in client mode if any output needs
+	// bitmaps or audio. Server outputs are derived from that tree.
+	c.client = false
+	for _, out := range outputs {
+		c.client = c.client || out.Mode == Client
+	}
+
+	c.log.Info("parsing input", "outputs", len(outputs), "client", c.client)
+
+	// Parse WZ file
+	root, err := c.parseInputs()
+	if err != nil {
+		return nil, fmt.Errorf("parsing WZ file: %w", err)
+	}
+	c.finishTree(root)
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if stats := c.dedupStats; stats.Bitmaps > 0 || stats.Audio > 0 {
+		c.log.Info("deduplicated media", "bitmaps", stats.Bitmaps, "audio", stats.Audio,
+			"saved_bytes", stats.BitmapBytes+stats.AudioBytes)
+	}
+
+	result := &Result{Dedup: c.dedupStats}
+	for _, out := range outputs {
+		// Write NX file
+		w := c.forOutput(out, root)
+		size, err := w.writeNXFile()
+		if err != nil {
+			return nil, fmt.Errorf("writing NX file %s: %w", out.Filename, err)
+		}
+		result.Outputs = append(result.Outputs, w.outputResult(size))
+	}
+	result.Failures = c.failures
+	result.Warnings = c.warnings
+	return result, nil
+}
+
+// ConvertDirectory converts an already parsed WZ directory tree and writes
+// a single NX file in opts.Mode to w. opts.Outputs is ignored.
+func ConvertDirectory(dir *wz.WZDirectory, w io.Writer, opts Options) (*Result, error) {
+	return ConvertDirectoryContext(context.Background(), dir, w, opts)
+}
+
+// ConvertDirectoryContext is ConvertDirectory with a context. A cancelled
+// conversion may have written part of the file to w.
+func ConvertDirectoryContext(ctx context.Context, dir *wz.WZDirectory, w io.Writer, opts Options) (*Result, error) {
+	c := New("", "", opts)
+	done := c.withCancel(ctx)
+	result, err := c.convertDirectory(dir, w)
+	if err = done(err); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// convertDirectory converts dir and writes a single NX file to w
+func (c *Converter) convertDirectory(dir *wz.WZDirectory, w io.Writer) (*Result, error) {
+	root := newRootNode()
+	c.imagesTotal = c.countImages(dir)
+	c.progress(EventStart, StageTraverse, "", 0, int64(c.imagesTotal))
+	c.traverseWZDirectory(dir, root)
+	c.progress(EventEnd, StageTraverse, "", c.imagesDone.Load(), int64(c.imagesTotal))
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.finishTree(root)
+
+	out := c.forOutput(Output{Mode: c.opts.Mode}, root)
+	size, err := out.writeNXTo(w)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		Outputs:  []OutputResult{out.outputResult(size)},
+		Dedup:    c.dedupStats,
+		Failures: c.failures,
+		Warnings: c.warnings,
+	}, nil
+}
+
+// finishTree applies the passes that need the whole tree: UOL resolution
+// and sorting
+func (c *Converter) finishTree(root *Node) {
+	if len(c.uols) > 0 {
+		c.resolveUOLs(root)
+	}
+	if c.opts.Sort {
+		sortTree(root)
+	}
+}
+
+// sortTree orders the children of every node by name. Siblings with the
+// same name keep their order.
+func sortTree(node *Node) {
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+	for _, child := range node.Children {
+		sortTree(child)
+	}
+}
+
+// forOutput returns a converter that writes the parsed tree to out. The
+// bitmap and audio tables are shared, so bitmaps are compressed only once.
+func (c *Converter) forOutput(out Output, root *Node) *Converter {
+	w := &Converter{
+		wzFilename: c.wzFilename,
+		nxFilename: out.Filename,
+		nxWriter:   out.Writer,
+		opts:       c.opts,
+		ctx:        c.ctx,
+		client:     out.Mode == Client,
+		stringMap:  make(map[string]uint32),
+		log:        c.log.With("output", out.Filename),
+	}
+	if w.client {
+		w.bitmaps = c.bitmaps
+		w.audio = c.audio
+	} else if c.client {
+		root = w.serverTree(root)
+	}
+
+	// Add empty string at index 0
+	w.addString("")
+
+	// Flatten nodes into list (preserving order unless sorting)
+	w.log.Info("creating output", "mode", out.Mode)
+	w.log.Debug("flattening nodes", "root_children", len(root.Children))
+	w.flattenNodes(root)
+	w.log.Debug("flattened nodes", "nodes", len(w.nodes))
+	w.treeNodes = len(w.nodes)
+	if w.opts.DedupNodes {
+		w.treeNodes = countNodes(root)
+		w.log.Info("shared subtrees", "nodes", len(w.nodes), "tree_nodes", w.treeNodes)
+	}
+	return w
+}
+
+// outputResult describes the file written by an output converter
+func (c *Converter) outputResult(size int64) OutputResult {
+	mode := Server
+	if c.client {
+		mode = Client
+	}
+	return OutputResult{
+		Filename:  c.nxFilename,
+		Mode:      mode,
+		Size:      size,
+		Nodes:     len(c.nodes),
+		Strings:   len(c.strings),
+		Bitmaps:   len(c.bitmaps),
+		Audio:     len(c.audio),
+		TreeNodes: c.treeNodes,
+	}
+}
+
+// countNodes counts the nodes of a tree
+func countNodes(node *Node) int {
+	count := 1
+	for _, child := range node.Children {
+		count += countNodes(child)
+	}
+	return count
+}
+
+// serverTree copies a tree parsed in client mode, applying the server mode
+// rules: bitmap and audio nodes become None nodes, and bitmaps keep their
+// size as children when server dimensions are enabled.
+func (c *Converter) serverTree(node *Node) *Node {
+	copied := &Node{
+		Name:     node.Name,
+		Children: make([]*Node, 0, len(node.Children)),
+		Type:     node.Type,
+		Data:     node.Data,
+	}
+	for _, child := range node.Children {
+		copied.Children = append(copied.Children, c.serverTree(child))
+	}
+
+	switch node.Type {
+	case NodeTypeBitmap:
+		bitmap := node.Data.(BitmapNodeData)
+		copied.Type, copied.Data = NodeTypeNone, nil
+		if c.opts.ServerDimensions {
+			addMetadata(copied, MetadataWidth, int64(bitmap.Width))
+			addMetadata(copied, MetadataHeight, int64(bitmap.Height))
+		}
+	case NodeTypeAudio:
+		copied.Type, copied.Data = NodeTypeNone, nil
+	}
+	return copied
+}
+
+// parseWZFile is implemented in wzparser.go
+
+// writeNXFile writes the NX format file, or the output writer if one is
+// set, and returns its size. The file is written to a temporary file in
+// the same directory and renamed into place once complete.
+func (c *Converter) writeNXFile() (int64, error) {
+	if c.nxWriter != nil {
+		return c.writeNXTo(c.nxWriter)
+	}
+
+	dir, base := filepath.Split(c.nxFilename)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := createTemp(dir, base)
+	if err != nil {
+		return 0, err
+	}
+	tmpName := file.Name()
+
+	size, err := c.writeNXTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.moveNXFile(tmpName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return 0, err
+	}
+	return size, nil
+}
+
+// createTemp creates a new file for writing base in dir. Unlike
+// os.CreateTemp, which uses 0600, it creates the file with the permissions
+// os.Create gives, 0666 before the umask.
+func createTemp(dir, base string) (*os.File, error) {
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) && try < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// moveNXFile moves the finished temporary file to nxFilename. With
+// NoClobber it is linked instead, which fails if nxFilename exists, and
+// the temporary name is removed.
+func (c *Converter) moveNXFile(tmpName string) error {
+	if !c.opts.NoClobber {
+		return os.Rename(tmpName, c.nxFilename)
+	}
+	if err := os.Link(tmpName, c.nxFilename); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s already exists: %w", c.nxFilename, fs.ErrExist)
+		}
+		return err
+	}
+	os.Remove(tmpName)
+	return nil
+}
+
+// contextWriter fails writes once its context is cancelled
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// writeNXTo writes the NX format data to w through a large buffer and
+// returns the number of bytes written. Cancellation is checked whenever
+// the buffer is flushed.
+func (c *Converter) writeNXTo(w io.Writer) (int64, error) {
+	// Create buffered writer with large buffer for improved write performance
+	bufferSize := BufferSizeMB * 1024 * 1024
+	bufferedWriter := bufio.NewWriterSize(&contextWriter{ctx: c.ctx, w: w}, bufferSize)
+
+	size, err := c.writeNXData(bufferedWriter)
+	if err != nil {
+		return 0, err
+	}
+
+	// Ensure all data is flushed
+	if err := bufferedWriter.Flush(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// nxLayout holds the offsets of every section and entry of an NX file.
+// It is computed before anything is written, so the header can come first
+// and the file is written strictly sequentially.
+type nxLayout struct {
+	nodeOffset        uint64
+	stringOffsets     []uint64
+	stringTableOffset uint64
+	bitmapOffsets     []uint64
+	bitmapTableOffset uint64 // zero without bitmaps
+	audioOffsets      []uint64
+	audioTableOffset  uint64 // zero without audio
+	size              uint64
+}
+
+// layout computes the layout of the NX file: header, nodes, string data
+// and offset table, then in client mode bitmap data and offset table and
+// audio data and offset table. Bitmaps must already be compressed.
+func (c *Converter) layout() *nxLayout {
+	l := &nxLayout{nodeOffset: nxHeaderSize}
+	pos := l.nodeOffset + uint64(len(c.nodes))*nxNodeSize
+
+	// Strings are a 2 byte length and the UTF-8 data
+	l.stringOffsets = make([]uint64, len(c.strings))
+	for i, str := range c.strings {
+		l.stringOffsets[i] = pos
+		pos += 2 + uint64(len(str))
+	}
+	l.stringTableOffset = pos
+	pos += 8 * uint64(len(c.strings))
+
+	if c.client && len(c.bitmaps) > 0 {
+		// Bitmaps are width, height, compressed size and the data
+		l.bitmapOffsets = make([]uint64, len(c.bitmaps))
+		for i, bitmap := range c.bitmaps {
+			l.bitmapOffsets[i] = pos
+			pos += 8 + uint64(len(bitmap.CompressedData))
+		}
+		l.bitmapTableOffset = pos
+		pos += 8 * uint64(len(c.bitmaps))
+	}
+
+	if c.client && len(c.audio) > 0 {
+		l.audioOffsets = make([]uint64, len(c.audio))
+		for i, audio := range c.audio {
+			l.audioOffsets[i] = pos
+			pos += uint64(len(audio.CompressedData))
+		}
+		l.audioTableOffset = pos
+		pos += 8 * uint64(len(c.audio))
+	}
+
+	l.size = pos
+	return l
+}
+
+// countingWriter counts the bytes written through it and reports them as
+// write progress about once per percent of total
+type countingWriter struct {
+	w     io.Writer
+	n     int64
+	c     *Converter
+	total int64
+	next  int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if cw.n >= cw.next {
+		cw.c.progress(EventProgress, StageWrite, cw.c.nxFilename, cw.n, cw.total)
+		cw.next = cw.n + cw.total/100 + 1
+	}
+	return n, err
+}
+
+// writeNXData writes the actual NX format data and returns its size. The
+// data is written sequentially, so w needs no seeking.
+func (c *Converter) writeNXData(w io.Writer) (int64, error) {
+	// Every entry must have its final size before the layout is computed
+	if c.client {
+		if len(c.bitmaps) > 0 {
+			c.log.Info("compressing bitmaps", "count", len(c.bitmaps))
+			if err := c.compressBitmapsParallel(); err != nil {
+				return 0, err
+			}
+		}
+		for i := range c.audio {
+			// Audio is stored as is, matching C++ behavior
+			if len(c.audio[i].CompressedData) == 0 {
+				c.audio[i].CompressedData = c.audio[i].Data
+			}
+		}
+	}
+	// Node names and string values join the string table in node order,
+	// the order writeNodes looks them up
+	for _, node := range c.nodes {
+		c.addString(node.Name)
+		if node.Type == NodeTypeString {
+			c.addString(node.Data.(string))
+		}
+	}
+	layout := c.layout()
+	total := int64(layout.size)
+	counter := &countingWriter{w: w, c: c, total: total, next: total/100 + 1}
+	c.progress(EventStart, StageWrite, c.nxFilename, 0, total)
+
+	if err := c.writeHeader(counter, layout); err != nil {
+		return 0, err
+	}
+
+	// Write nodes
+	c.log.Info("writing nodes", "count", len(c.nodes))
+	if err := c.writeNodes(counter); err != nil {
+		return 0, err
+	}
+
+	// Write string data and offset table
+	c.log.Info("writing strings", "count", len(c.strings))
+	if err := c.writeStrings(counter, layout); err != nil {
+		return 0, err
+	}
+
+	// Write bitmaps and audio if in client mode
+	if layout.bitmapOffsets != nil {
+		c.log.Info("writing bitmaps", "count", len(c.bitmaps))
+		if err := c.writeBitmaps(counter, layout); err != nil {
+			return 0, err
+		}
+	}
+	if layout.audioOffsets != nil {
+		c.log.Info("writing audio", "count", len(c.audio))
+		if err := c.writeAudio(counter, layout); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(counter.n) != layout.size {
+		return 0, fmt.Errorf("wrote %d bytes but the layout has %d", counter.n, layout.size)
+	}
+	c.progress(EventEnd, StageWrite, c.nxFilename, counter.n, total)
+	return counter.n, nil
+}
+
+// writeHeader writes the NX file header
+func (c *Converter) writeHeader(w io.Writer, layout *nxLayout) error {
+	// NX Header:
+	// 4 bytes: magic "PKG4"
+	// 4 bytes: node count
+	// 8 bytes: node offset (52 bytes from start)
+	// 4 bytes: string count
+	// 8 bytes: string offset table offset
+	// 4 bytes: bitmap count
+	// 8 bytes: bitmap offset table offset
+	// 4 bytes: audio count
+	// 8 bytes: audio offset table offset
+
+	// Write magic
+	if _, err := w.Write([]byte(NXMagic)); err != nil {
+		return err
+	}
+
+	fields := []interface{}{
+		uint32(len(c.nodes)), layout.nodeOffset,
+		uint32(len(c.strings)), layout.stringTableOffset,
+		uint32(len(c.bitmaps)), layout.bitmapTableOffset,
+		uint32(len(c.audio)), layout.audioTableOffset,
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeNodes writes all nodes to the file
+// IMPORTANT: Does NOT sort nodes - preserves original order
+func (c *Converter) writeNodes(w io.Writer) error {
+	// Node structure (20 bytes):
+	// 4 bytes: name string ID
+	// 4 bytes: first child index
+	// 2 bytes: child count
+	// 2 bytes: type
+	// 8 bytes: data (type-dependent)
+
+	for _, node := range c.nodes {
+		nameID := c.getStringID(node.Name)
+
+		// Calculate child info
+		var firstChild uint32 = 0
+		var childCount uint16 = 0
+		if len(node.Children) > 0 {
+			firstChild = node.firstChild
+			childCount = uint16(len(node.Children))
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, nameID); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, firstChild); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, childCount); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, node.Type); err != nil {
+			return err
+		}
+
+		// Write data based on type
+		if err := c.writeNodeData(w, node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeNodeData writes type-specific node data
+func (c *Converter) writeNodeData(w io.Writer, node *Node) error {
+	var err error
+	switch node.Type {
+	case NodeTypeNone:
+		err = binary.Write(w, binary.LittleEndian, uint64(0))
+	case NodeTypeInt64:
+		err = binary.Write(w, binary.LittleEndian, node.Data.(int64))
+	case NodeTypeDouble:
+		err = binary.Write(w, binary.LittleEndian, node.Data.(float64))
+	case NodeTypeString:
+		strID := c.getStringID(node.Data.(string))
+		if err = binary.Write(w, binary.LittleEndian, uint32(strID)); err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.LittleEndian, uint32(0)) // padding
+	case NodeTypePOINT:
+		point := node.Data.([2]int32)
+		if err = binary.Write(w, binary.LittleEndian, point[0]); err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.LittleEndian, point[1])
+	case NodeTypeBitmap:
+		bitmapData := node.Data.(BitmapNodeData)
+		if err = binary.Write(w, binary.LittleEndian, bitmapData.ID); err != nil {
+			return err
+		}
+		if err = binary.Write(w, binary.LittleEndian, bitmapData.Width); err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.LittleEndian, bitmapData.Height)
+	case NodeTypeAudio:
+		audioData := node.Data.(AudioNodeData)
+		if err = binary.Write(w, binary.LittleEndian, audioData.ID); err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.LittleEndian, audioData.Length)
+	default:
+		err = binary.Write(w, binary.LittleEndian, uint64(0))
+	}
+	return err
+}
+
+// writeOffsetTable writes a table of 8 byte offsets
+func writeOffsetTable(w io.Writer, offsets []uint64) error {
+	for _, offset := range offsets {
+		if err := binary.Write(w, binary.LittleEndian, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStrings writes the string data and offset table
+func (c *Converter) writeStrings(w io.Writer, layout *nxLayout) error {
+	for _, str := range c.strings {
+		// String format:
+		// 2 bytes: length
+		// N bytes: UTF-8 string data
+		length := uint16(len(str))
+		if err := binary.Write(w, binary.LittleEndian, length); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, str); err != nil {
+			return err
+		}
+	}
+	return writeOffsetTable(w, layout.stringOffsets)
+}
+
+// writeBitmaps writes bitmap data and offset table
+func (c *Converter) writeBitmaps(w io.Writer, layout *nxLayout) error {
+	for _, bitmap := range c.bitmaps {
+		// Bitmap format:
+		// 2 bytes: width
+		// 2 bytes: height
+		// 4 bytes: compressed data size
+		// N bytes: compressed data
+		if err := binary.Write(w, binary.LittleEndian, bitmap.Width); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, bitmap.Height); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(bitmap.CompressedData))); err != nil {
+			return err
+		}
+		if _, err := w.Write(bitmap.CompressedData); err != nil {
+			return err
+		}
+	}
+	return writeOffsetTable(w, layout.bitmapOffsets)
+}
+
+// writeAudio writes audio data and offset table
+func (c *Converter) writeAudio(w io.Writer, layout *nxLayout) error {
+	for _, audio := range c.audio {
+		// Write audio data directly (no length prefix in the data section)
+		if _, err := w.Write(audio.CompressedData); err != nil {
+			return err
+		}
+	}
+	return writeOffsetTable(w, layout.audioOffsets)
+}
+
+// addBitmap stores a bitmap and returns its ID. With deduplication,
+// bitmaps with identical pixels and size share one ID.
+func (c *Converter) addBitmap(bitmap BitmapData) uint32 {
+	var key bitmapKey
+	if !c.opts.NoDedup {
+		key = bitmapKey{hash: sha256.Sum256(bitmap.Data), width: bitmap.Width, height: bitmap.Height}
+	}
+
+	// Images are traversed in parallel
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.opts.NoDedup {
+		if id, exists := c.bitmapIDs[key]; exists {
+			c.dedupStats.Bitmaps++
+			c.dedupStats.BitmapBytes += uint64(len(bitmap.Data))
+			return id
+		}
+		c.bitmapIDs[key] = uint32(len(c.bitmaps))
+	}
+	c.bitmaps = append(c.bitmaps, bitmap)
+	return uint32(len(c.bitmaps) - 1)
+}
+
+// addAudio stores an audio entry and returns its ID. With deduplication,
+// identical payloads share one ID.
+func (c *Converter) addAudio(audio AudioData) uint32 {
+	var key [sha256.Size]byte
+	if !c.opts.NoDedup {
+		key = sha256.Sum256(audio.Data)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.opts.NoDedup {
+		if id, exists := c.audioIDs[key]; exists {
+			c.dedupStats.Audio++
+			c.dedupStats.AudioBytes += uint64(len(audio.Data))
+			return id
+		}
+		c.audioIDs[key] = uint32(len(c.audio))
+	}
+	c.audio = append(c.audio, audio)
+	return uint32(len(c.audio) - 1)
+}
+
+// addString adds a string to the string table and returns its ID
+func (c *Converter) addString(str string) uint32 {
+	if id, exists := c.stringMap[str]; exists {
+		return id
+	}
+	id := uint32(len(c.strings))
+	c.strings = append(c.strings, str)
+	c.stringMap[str] = id
+	return id
+}
+
+// getStringID returns the ID for a string
+func (c *Converter) getStringID(str string) uint32 {
+	if id, exists := c.stringMap[str]; exists {
+		return id
+	}
+	return c.addString(str)
+}
+
+// compressBitmapsParallel compresses all bitmap data in parallel
+func (c *Converter) compressBitmapsParallel() error {
+	if len(c.bitmaps) == 0 {
+		return nil
+	}
+
+	// Create error channel and wait group
+	errChan := make(chan error, len(c.bitmaps))
+	var wg sync.WaitGroup
+
+	semaphore := make(chan struct{}, c.workers())
+	var done atomic.Int64
+	var total int64
+	for i := range c.bitmaps {
+		if len(c.bitmaps[i].CompressedData) == 0 && len(c.bitmaps[i].Data) > 0 {
+			total++
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	c.progress(EventStart, StageCompress, c.nxFilename, 0, total)
+
+	for i := range c.bitmaps {
+		// Skip if already compressed or no data
+		if len(c.bitmaps[i].CompressedData) > 0 || len(c.bitmaps[i].Data) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+
+			// Acquire semaphore, unless the conversion was cancelled
+			select {
+			case semaphore <- struct{}{}:
+			case <-c.ctx.Done():
+				errChan <- c.ctx.Err()
+				return
+			}
+			defer func() { <-semaphore }()
+			if err := c.ctx.Err(); err != nil {
+				errChan <- err
+				return
+			}
+
+			// Compress the bitmap data
+			compressed, err := c.compressData(c.bitmaps[index].Data)
+			if err != nil {
+				errChan <- fmt.Errorf("compressing bitmap %d: %w", index, err)
+				return
+			}
+			c.bitmaps[index].CompressedData = compressed
+			c.progress(EventProgress, StageCompress, c.nxFilename, done.Add(1), total)
+		}(i)
+	}
+
+	// Wait for all compressions to complete
+	wg.Wait()
+	close(errChan)
+
+	// Check for any errors
+	for err := range errChan {
+		if err != nil {
+			return err
+		}
+	}
+
+	c.progress(EventEnd, StageCompress, c.nxFilename, done.Load(), total)
+	return nil
+}
+
+// flattenNodes flattens the node tree into a list
+// IMPORTANT: Ensures each parent's children are stored contiguously in the array,
+// as required by the NX format (children at indices [firstChild, firstChild+count-1])
+//
+// With node deduplication, a node whose children are identical to those of
+// an earlier node reuses that node's child range instead of laying out its
+// own copy of the subtree.
+func (c *Converter) flattenNodes(root *Node) {
+	var queue []*Node
+	queue = append(queue, root)
+	enqueued := uint32(1)
+	debug := c.log.Enabled(c.ctx, slog.LevelDebug)
+
+	var ranges map[[sha256.Size]byte]uint32
+	var hashes map[*Node][sha256.Size]byte
+	if c.opts.DedupNodes {
+		ranges = make(map[[sha256.Size]byte]uint32)
+		hashes = make(map[*Node][sha256.Size]byte)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		nodeIndex := len(c.nodes)
+		c.nodes = append(c.nodes, node)
+
+		// Log detailed information for portal nodes
+		if debug && (node.Name == "portal" || (len(node.Children) > 0 && len(node.Children) <= 20)) {
+			c.log.Debug("node", "index", nodeIndex, "name", node.Name, "children", len(node.Children))
+			for i, child := range node.Children {
+				// Try to extract coordinates if this is a POINT type or has POINT children
+				args := []any{"parent", nodeIndex, "index", i, "name", child.Name}
+				for _, grandchild := range child.Children {
+					if grandchild.Type == NodeTypePOINT {
+						if data, ok := grandchild.Data.([2]int32); ok {
+							args = append(args, "x", data[0], "y", data[1])
+							break
+						}
+					}
+				}
+				c.log.Debug("child", args...)
+			}
+		}
+
+		if len(node.Children) == 0 {
+			continue
+		}
+		if c.opts.DedupNodes {
+			key := childrenHash(node, hashes)
+			if first, exists := ranges[key]; exists {
+				node.firstChild = first
+				continue
+			}
+			ranges[key] = enqueued
+		}
+
+		// Add all children to the queue so they get added contiguously
+		node.firstChild = enqueued
+		enqueued += uint32(len(node.Children))
+		queue = append(queue, node.Children...)
+	}
+}
+
+// childrenHash hashes the children of a node, including their names,
+// types, values and subtrees. Nodes with equal hashes can share one child
+// range. hashes memoizes the subtree hash of every node.
+func childrenHash(node *Node, hashes map[*Node][sha256.Size]byte) [sha256.Size]byte {
+	h := sha256.New()
+	var buf [8]byte
+	writeUint := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	writeString := func(s string) {
+		writeUint(uint64(len(s)))
+		h.Write([]byte(s))
+	}
+
+	writeUint(uint64(len(node.Children)))
+	for _, child := range node.Children {
+		sub, ok := hashes[child]
+		if !ok {
+			sub = childrenHash(child, hashes)
+			hashes[child] = sub
+		}
+		writeString(child.Name)
+		writeUint(uint64(child.Type))
+		switch data := child.Data.(type) {
+		case int64:
+			writeUint(uint64(data))
+		case float64:
+			writeUint(math.Float64bits(data))
+		case string:
+			writeString(data)
+		case [2]int32:
+			writeUint(uint64(uint32(data[0]))<<32 | uint64(uint32(data[1])))
+		case BitmapNodeData:
+			writeUint(uint64(data.ID)<<32 | uint64(data.Width)<<16 | uint64(data.Height))
+		case AudioNodeData:
+			writeUint(uint64(data.ID)<<32 | uint64(data.Length))
+		}
+		h.Write(sub[:])
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
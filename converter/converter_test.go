@@ -0,0 +1,1647 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/internal/wztest"
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+func TestNodeTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeType uint16
+		expected string
+	}{
+		{"None", NodeTypeNone, "None"},
+		{"Int64", NodeTypeInt64, "Int64"},
+		{"Double", NodeTypeDouble, "Double"},
+		{"String", NodeTypeString, "String"},
+		{"Point", NodeTypePOINT, "Point"},
+		{"Bitmap", NodeTypeBitmap, "Bitmap"},
+		{"Audio", NodeTypeAudio, "Audio"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.nodeType > 6 {
+				t.Errorf("Invalid node type: %d", tt.nodeType)
+			}
+		})
+	}
+}
+
+func TestStringDeduplication(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{})
+
+	// Add the same string multiple times
+	id1 := converter.addString("test")
+	id2 := converter.addString("test")
+	id3 := converter.addString("different")
+	id4 := converter.addString("test")
+
+	if id1 != id2 || id1 != id4 {
+		t.Errorf("String deduplication failed: id1=%d, id2=%d, id4=%d", id1, id2, id4)
+	}
+
+	if id1 == id3 {
+		t.Errorf("Different strings should have different IDs: id1=%d, id3=%d", id1, id3)
+	}
+
+	// Should have "test" and "different" = 2 strings
+	if len(converter.strings) != 2 {
+		t.Errorf("Expected 2 strings, got %d", len(converter.strings))
+	}
+}
+
+func TestNodeFlattening(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{})
+
+	// Create a simple node tree
+	root := &Node{
+		Name:     "root",
+		Type:     NodeTypeNone,
+		Children: []*Node{},
+	}
+
+	child1 := &Node{
+		Name:     "child1",
+		Type:     NodeTypeInt64,
+		Data:     int64(42),
+		Children: []*Node{},
+	}
+
+	child2 := &Node{
+		Name:     "child2",
+		Type:     NodeTypeString,
+		Data:     "hello",
+		Children: []*Node{},
+	}
+
+	root.Children = append(root.Children, child1, child2)
+
+	// Flatten the tree
+	converter.flattenNodes(root)
+
+	// Should have 3 nodes: root, child1, child2
+	if len(converter.nodes) != 3 {
+		t.Errorf("Expected 3 nodes, got %d", len(converter.nodes))
+	}
+
+	// Check order is preserved (not sorted)
+	if converter.nodes[0].Name != "root" {
+		t.Errorf("Expected first node to be root, got %s", converter.nodes[0].Name)
+	}
+	if converter.nodes[1].Name != "child1" {
+		t.Errorf("Expected second node to be child1, got %s", converter.nodes[1].Name)
+	}
+	if converter.nodes[2].Name != "child2" {
+		t.Errorf("Expected third node to be child2, got %s", converter.nodes[2].Name)
+	}
+}
+
+func TestNodeFlatteningWithNesting(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{})
+
+	// Create a more complex tree structure:
+	// root
+	//   ├─ child1
+	//   │   └─ grandchild1
+	//   └─ child2
+	//       ├─ grandchild2
+	//       └─ grandchild3
+
+	grandchild1 := &Node{Name: "grandchild1", Type: NodeTypeInt64, Data: int64(1), Children: []*Node{}}
+	grandchild2 := &Node{Name: "grandchild2", Type: NodeTypeInt64, Data: int64(2), Children: []*Node{}}
+	grandchild3 := &Node{Name: "grandchild3", Type: NodeTypeInt64, Data: int64(3), Children: []*Node{}}
+
+	child1 := &Node{
+		Name:     "child1",
+		Type:     NodeTypeNone,
+		Children: []*Node{grandchild1},
+	}
+
+	child2 := &Node{
+		Name:     "child2",
+		Type:     NodeTypeNone,
+		Children: []*Node{grandchild2, grandchild3},
+	}
+
+	root := &Node{
+		Name:     "root",
+		Type:     NodeTypeNone,
+		Children: []*Node{child1, child2},
+	}
+
+	// Flatten the tree
+	converter.flattenNodes(root)
+
+	// Expected order with breadth-first:
+	// 0: root
+	// 1: child1
+	// 2: child2
+	// 3: grandchild1
+	// 4: grandchild2
+	// 5: grandchild3
+
+	if len(converter.nodes) != 6 {
+		t.Fatalf("Expected 6 nodes, got %d", len(converter.nodes))
+	}
+
+	expectedOrder := []string{"root", "child1", "child2", "grandchild1", "grandchild2", "grandchild3"}
+	for i, expected := range expectedOrder {
+		if converter.nodes[i].Name != expected {
+			t.Errorf("Node at index %d: expected %s, got %s", i, expected, converter.nodes[i].Name)
+		}
+	}
+
+	// Verify root's children are at indices 1 and 2 (contiguous)
+	// Find root's first child index
+	var rootFirstChild uint32
+	for i, n := range converter.nodes {
+		if n == root.Children[0] {
+			rootFirstChild = uint32(i)
+			break
+		}
+	}
+	if rootFirstChild != 1 {
+		t.Errorf("Root's first child should be at index 1, got %d", rootFirstChild)
+	}
+	// Second child should be at index 2 (rootFirstChild + 1)
+	if converter.nodes[2] != root.Children[1] {
+		t.Errorf("Root's second child should be at index 2")
+	}
+
+	// Verify child2's children are at indices 4 and 5 (contiguous)
+	var child2FirstChild uint32
+	for i, n := range converter.nodes {
+		if n == child2.Children[0] {
+			child2FirstChild = uint32(i)
+			break
+		}
+	}
+	if child2FirstChild != 4 {
+		t.Errorf("Child2's first child should be at index 4, got %d", child2FirstChild)
+	}
+	if converter.nodes[5] != child2.Children[1] {
+		t.Errorf("Child2's second child should be at index 5")
+	}
+}
+
+func TestColorTables(t *testing.T) {
+	// Test table4
+	if table4[0] != 0x00 || table4[15] != 0xFF {
+		t.Error("table4 values incorrect")
+	}
+
+	// Test table5
+	if table5[0] != 0x00 || table5[31] != 0xFF {
+		t.Error("table5 values incorrect")
+	}
+
+	// Test table6
+	if table6[0] != 0x00 || table6[63] != 0xFF {
+		t.Error("table6 values incorrect")
+	}
+}
+
+func TestRGB565Conversion(t *testing.T) {
+	// Test converting a simple RGB565 image (1x1 pixel)
+	data := []byte{0xFF, 0xFF} // White pixel in RGB565
+	output, err := convertRGB565(data, 1, 1)
+
+	if err != nil {
+		t.Errorf("RGB565 conversion failed: %v", err)
+	}
+
+	if len(output) != 4 {
+		t.Errorf("Expected 4 bytes (RGBA), got %d", len(output))
+	}
+
+	// Check that alpha is 255 (fully opaque)
+	if output[3] != 255 {
+		t.Errorf("Expected alpha to be 255, got %d", output[3])
+	}
+}
+
+func TestARGB8888Conversion(t *testing.T) {
+	// Test converting ARGB8888 (BGRA in WZ) to RGBA
+	data := []byte{0xFF, 0x00, 0x00, 0x80} // Blue pixel with alpha
+	output, err := convertARGB8888(data, 1, 1)
+
+	if err != nil {
+		t.Errorf("ARGB8888 conversion failed: %v", err)
+	}
+
+	if len(output) != 4 {
+		t.Errorf("Expected 4 bytes (RGBA), got %d", len(output))
+	}
+
+	// Check color channel swap (BGRA -> RGBA)
+	if output[0] != 0x00 || output[1] != 0x00 || output[2] != 0xFF || output[3] != 0x80 {
+		t.Errorf("Color channels not swapped correctly: R=%d, G=%d, B=%d, A=%d",
+			output[0], output[1], output[2], output[3])
+	}
+}
+
+func TestScaleImage(t *testing.T) {
+	// Test scaling a 2x2 image by 2x
+	data := []byte{
+		// Pixel 0,0: Red
+		255, 0, 0, 255,
+		// Pixel 1,0: Green
+		0, 255, 0, 255,
+		// Pixel 0,1: Blue
+		0, 0, 255, 255,
+		// Pixel 1,1: White
+		255, 255, 255, 255,
+	}
+
+	scaled := scaleImage(data, 2, 2, 2)
+
+	// Should now be 4x4 = 16 pixels = 64 bytes
+	expectedSize := 4 * 4 * 4
+	if len(scaled) != expectedSize {
+		t.Errorf("Expected %d bytes for scaled image, got %d", expectedSize, len(scaled))
+	}
+
+	// Check that first pixel is still red (top-left corner)
+	if scaled[0] != 255 || scaled[1] != 0 || scaled[2] != 0 || scaled[3] != 255 {
+		t.Errorf("First pixel not red: R=%d, G=%d, B=%d, A=%d",
+			scaled[0], scaled[1], scaled[2], scaled[3])
+	}
+}
+
+func TestScaleImageNoScale(t *testing.T) {
+	// Test that scale factor of 1 returns original data
+	data := []byte{255, 0, 0, 255}
+	scaled := scaleImage(data, 1, 1, 1)
+
+	if len(scaled) != len(data) {
+		t.Errorf("Scale factor 1 should not change size")
+	}
+
+	for i := range data {
+		if scaled[i] != data[i] {
+			t.Errorf("Scale factor 1 should return identical data")
+			break
+		}
+	}
+}
+
+func TestParallelBitmapCompression(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+
+	// Create test bitmap data
+	testData := make([]byte, 1000)
+	for i := range testData {
+		testData[i] = byte(i % 256)
+	}
+
+	// Add multiple bitmaps
+	for i := 0; i < 10; i++ {
+		bitmap := BitmapData{
+			Width:  10,
+			Height: 10,
+			Data:   testData,
+		}
+		converter.bitmaps = append(converter.bitmaps, bitmap)
+	}
+
+	// Compress in parallel
+	err := converter.compressBitmapsParallel()
+	if err != nil {
+		t.Errorf("Parallel bitmap compression failed: %v", err)
+	}
+
+	// Verify all bitmaps were compressed
+	for i, bitmap := range converter.bitmaps {
+		if len(bitmap.CompressedData) == 0 {
+			t.Errorf("Bitmap %d was not compressed", i)
+		}
+	}
+}
+
+func TestParallelCompressionWithEmptyBitmaps(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+
+	// Add bitmaps with no data
+	for i := 0; i < 5; i++ {
+		bitmap := BitmapData{
+			Width:  10,
+			Height: 10,
+			Data:   []byte{},
+		}
+		converter.bitmaps = append(converter.bitmaps, bitmap)
+	}
+
+	// Should not fail with empty bitmaps
+	err := converter.compressBitmapsParallel()
+	if err != nil {
+		t.Errorf("Parallel compression should handle empty bitmaps: %v", err)
+	}
+}
+
+func TestParallelCompressionWithAlreadyCompressed(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+
+	// Add already compressed bitmaps
+	for i := 0; i < 5; i++ {
+		bitmap := BitmapData{
+			Width:          10,
+			Height:         10,
+			Data:           []byte{1, 2, 3},
+			CompressedData: []byte{4, 5, 6}, // Already compressed
+		}
+		converter.bitmaps = append(converter.bitmaps, bitmap)
+	}
+
+	// Should skip already compressed bitmaps
+	err := converter.compressBitmapsParallel()
+	if err != nil {
+		t.Errorf("Parallel compression failed: %v", err)
+	}
+
+	// Verify compressed data was not changed
+	for i, bitmap := range converter.bitmaps {
+		if len(bitmap.CompressedData) != 3 || bitmap.CompressedData[0] != 4 {
+			t.Errorf("Bitmap %d compressed data was modified", i)
+		}
+	}
+}
+
+// Helper for testing - a seekable buffer
+type seekableBuffer struct {
+	buf    []byte
+	pos    int64
+	maxPos int64 // Track maximum position written
+}
+
+func newSeekableBuffer() *seekableBuffer {
+	return &seekableBuffer{buf: make([]byte, 0, 1024*1024)}
+}
+
+func (s *seekableBuffer) Write(p []byte) (n int, err error) {
+	// Extend buffer if needed
+	minLen := int(s.pos) + len(p)
+	if minLen > len(s.buf) {
+		newBuf := make([]byte, minLen)
+		copy(newBuf, s.buf)
+		s.buf = newBuf
+	}
+
+	n = copy(s.buf[s.pos:], p)
+	s.pos += int64(n)
+
+	// Track max position
+	if s.pos > s.maxPos {
+		s.maxPos = s.pos
+	}
+
+	return n, nil
+}
+
+func (s *seekableBuffer) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case 0: // io.SeekStart
+		abs = offset
+	case 1: // io.SeekCurrent
+		abs = s.pos + offset
+	case 2: // io.SeekEnd
+		abs = int64(len(s.buf)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+
+	s.pos = abs
+
+	// Extend buffer if seeking beyond current length
+	if int(s.pos) > len(s.buf) {
+		newBuf := make([]byte, s.pos)
+		copy(newBuf, s.buf)
+		s.buf = newBuf
+	}
+
+	return abs, nil
+}
+
+func (s *seekableBuffer) Bytes() []byte {
+	return s.buf[:s.maxPos]
+}
+
+// TestNXFileFormat validates the NX file format structure
+func TestNXFileFormat(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+
+	// Add test data
+	converter.addString("")       // Empty string at index 0
+	converter.addString("root")   // Index 1
+	converter.addString("child1") // Index 2
+	converter.addString("child2") // Index 3
+	converter.addString("value")  // Index 4
+
+	// Create test nodes
+	root := &Node{
+		Name:     "",
+		Children: []*Node{},
+		Type:     NodeTypeNone,
+	}
+
+	child1 := &Node{
+		Name:     "child1",
+		Children: []*Node{},
+		Type:     NodeTypeInt64,
+		Data:     int64(42),
+	}
+
+	child2 := &Node{
+		Name:     "child2",
+		Children: []*Node{},
+		Type:     NodeTypeString,
+		Data:     "value",
+	}
+
+	root.Children = append(root.Children, child1, child2)
+
+	// Add a test bitmap
+	bitmapData := make([]byte, 100)
+	bitmap := BitmapData{
+		Width:          10,
+		Height:         10,
+		Data:           bitmapData,
+		CompressedData: []byte{1, 2, 3, 4}, // Fake compressed data
+	}
+	converter.bitmaps = append(converter.bitmaps, bitmap)
+
+	bitmapNode := &Node{
+		Name:     "bitmap",
+		Children: []*Node{},
+		Type:     NodeTypeBitmap,
+		Data: BitmapNodeData{
+			ID:     0,
+			Width:  10,
+			Height: 10,
+		},
+	}
+	root.Children = append(root.Children, bitmapNode)
+
+	// Add a test audio
+	audioData := []byte{5, 6, 7, 8, 9}
+	audio := AudioData{
+		Length:         uint32(len(audioData)),
+		Data:           audioData,
+		CompressedData: audioData,
+	}
+	converter.audio = append(converter.audio, audio)
+
+	audioNode := &Node{
+		Name:     "audio",
+		Children: []*Node{},
+		Type:     NodeTypeAudio,
+		Data: AudioNodeData{
+			ID:     0,
+			Length: uint32(len(audioData)),
+		},
+	}
+	root.Children = append(root.Children, audioNode)
+
+	// Flatten nodes
+	converter.flattenNodes(root)
+
+	// Write to buffer
+	buf := newSeekableBuffer()
+	_, err := converter.writeNXData(buf)
+	if err != nil {
+		t.Fatalf("Failed to write NX data: %v", err)
+	}
+
+	// Validate header
+	reader := bytes.NewReader(buf.Bytes())
+
+	// Check magic
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(reader, magic)
+	if err != nil {
+		t.Fatalf("Failed to read magic: %v", err)
+	}
+	if string(magic) != "PKG4" {
+		t.Errorf("Invalid magic: got %s, want PKG4", string(magic))
+	}
+
+	// Read header fields
+	var nodeCount uint32
+	var nodeOffset uint64
+	var stringCount uint32
+	var stringOffsetTableOffset uint64
+	var bitmapCount uint32
+	var bitmapOffsetTableOffset uint64
+	var audioCount uint32
+	var audioOffsetTableOffset uint64
+
+	if err := binary.Read(reader, binary.LittleEndian, &nodeCount); err != nil {
+		t.Fatalf("Failed to read node count: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &nodeOffset); err != nil {
+		t.Fatalf("Failed to read node offset: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &stringCount); err != nil {
+		t.Fatalf("Failed to read string count: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &stringOffsetTableOffset); err != nil {
+		t.Fatalf("Failed to read string offset: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &bitmapCount); err != nil {
+		t.Fatalf("Failed to read bitmap count: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &bitmapOffsetTableOffset); err != nil {
+		t.Fatalf("Failed to read bitmap offset: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &audioCount); err != nil {
+		t.Fatalf("Failed to read audio count: %v", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &audioOffsetTableOffset); err != nil {
+		t.Fatalf("Failed to read audio offset: %v", err)
+	}
+
+	// Validate counts
+	if nodeCount != uint32(len(converter.nodes)) {
+		t.Errorf("Node count mismatch: got %d, want %d", nodeCount, len(converter.nodes))
+	}
+	if stringCount != uint32(len(converter.strings)) {
+		t.Errorf("String count mismatch: got %d, want %d", stringCount, len(converter.strings))
+	}
+	if bitmapCount != uint32(len(converter.bitmaps)) {
+		t.Errorf("Bitmap count mismatch: got %d, want %d", bitmapCount, len(converter.bitmaps))
+	}
+	if audioCount != uint32(len(converter.audio)) {
+		t.Errorf("Audio count mismatch: got %d, want %d", audioCount, len(converter.audio))
+	}
+
+	// Validate node offset
+	if nodeOffset != 52 {
+		t.Errorf("Node offset should be 52 (header size), got %d", nodeOffset)
+	}
+
+	// Validate string offset table is after strings
+	if stringOffsetTableOffset <= nodeOffset {
+		t.Errorf("String offset table should be after nodes, got %d", stringOffsetTableOffset)
+	}
+
+	// Validate bitmap offset table is after bitmaps
+	if bitmapOffsetTableOffset <= stringOffsetTableOffset {
+		t.Errorf("Bitmap offset table should be after string offset table, got %d", bitmapOffsetTableOffset)
+	}
+
+	// Validate audio offset table is after audio
+	if audioOffsetTableOffset <= bitmapOffsetTableOffset {
+		t.Errorf("Audio offset table should be after bitmap offset table, got %d", audioOffsetTableOffset)
+	}
+
+	t.Logf("Header validation passed:")
+	t.Logf("  Node count: %d at offset %d", nodeCount, nodeOffset)
+	t.Logf("  String count: %d, offset table at %d", stringCount, stringOffsetTableOffset)
+	t.Logf("  Bitmap count: %d, offset table at %d", bitmapCount, bitmapOffsetTableOffset)
+	t.Logf("  Audio count: %d, offset table at %d", audioCount, audioOffsetTableOffset)
+}
+
+// TestNXFileFormatReading tests that we can read back what we write
+func TestNXFileFormatReading(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+
+	// Add test strings
+	converter.addString("")
+	converter.addString("testStr1")
+	converter.addString("testStr2")
+
+	// Create simple node tree
+	root := &Node{
+		Name:     "",
+		Children: []*Node{},
+		Type:     NodeTypeNone,
+	}
+
+	stringNode := &Node{
+		Name:     "testStr1",
+		Children: []*Node{},
+		Type:     NodeTypeString,
+		Data:     "testStr2",
+	}
+	root.Children = append(root.Children, stringNode)
+
+	// Add bitmap
+	bitmap := BitmapData{
+		Width:          5,
+		Height:         10,
+		Data:           make([]byte, 200),
+		CompressedData: []byte{1, 2, 3},
+	}
+	converter.bitmaps = append(converter.bitmaps, bitmap)
+
+	bitmapNode := &Node{
+		Name:     "bitmap",
+		Children: []*Node{},
+		Type:     NodeTypeBitmap,
+		Data: BitmapNodeData{
+			ID:     0,
+			Width:  5,
+			Height: 10,
+		},
+	}
+	root.Children = append(root.Children, bitmapNode)
+
+	// Add audio
+	audioData := []byte{0xAA, 0xBB, 0xCC}
+	audio := AudioData{
+		Length:         3,
+		Data:           audioData,
+		CompressedData: audioData,
+	}
+	converter.audio = append(converter.audio, audio)
+
+	audioNode := &Node{
+		Name:     "audio",
+		Children: []*Node{},
+		Type:     NodeTypeAudio,
+		Data: AudioNodeData{
+			ID:     0,
+			Length: 3,
+		},
+	}
+	root.Children = append(root.Children, audioNode)
+
+	converter.flattenNodes(root)
+
+	// Write to buffer
+	buf := newSeekableBuffer()
+	_, err := converter.writeNXData(buf)
+	if err != nil {
+		t.Fatalf("Failed to write NX data: %v", err)
+	}
+
+	// Now read back like gonx does
+	reader := bytes.NewReader(buf.Bytes())
+
+	// Read header
+	var header struct {
+		Magic                   [4]byte
+		NodeCount               uint32
+		NodeBlockOffset         int64
+		StringCount             uint32
+		StringOffsetTableOffset int64
+		BitmapCount             uint32
+		BitmapOffsetTableOffset int64
+		AudioCount              uint32
+		AudioOffsetTableOffset  int64
+	}
+
+	err = binary.Read(reader, binary.LittleEndian, &header)
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+
+	// Validate magic
+	// Validate magic
+	if string(header.Magic[:]) != "PKG4" {
+		t.Errorf("Invalid magic: %s", string(header.Magic[:]))
+	}
+
+	t.Logf("Header values:")
+	t.Logf("  String count: %d, offset table offset: %d", header.StringCount, header.StringOffsetTableOffset)
+	t.Logf("  Buffer size: %d", len(buf.Bytes()))
+	t.Logf("  Converter strings: %d", len(converter.strings))
+	if string(header.Magic[:]) != "PKG4" {
+		t.Errorf("Invalid magic: %s", string(header.Magic[:]))
+	}
+
+	// Read string offset table
+	_, err = reader.Seek(header.StringOffsetTableOffset, 0)
+	if err != nil {
+		t.Fatalf("Failed to seek to string offset table: %v", err)
+	}
+
+	stringOffsets := make([]int64, header.StringCount)
+	err = binary.Read(reader, binary.LittleEndian, &stringOffsets)
+	if err != nil {
+		t.Fatalf("Failed to read string offsets: %v", err)
+	}
+
+	// Read strings using offset table
+	for i, offset := range stringOffsets {
+		_, err = reader.Seek(offset, 0)
+		if err != nil {
+			t.Fatalf("Failed to seek to string %d: %v", i, err)
+		}
+
+		var length uint16
+		err = binary.Read(reader, binary.LittleEndian, &length)
+		if err != nil {
+			t.Fatalf("Failed to read string length: %v", err)
+		}
+
+		strBytes := make([]byte, length)
+		_, err = reader.Read(strBytes)
+		if err != nil {
+			t.Fatalf("Failed to read string data: %v", err)
+		}
+
+		t.Logf("String %d: %q", i, string(strBytes))
+	}
+
+	// Read bitmap offset table
+	_, err = reader.Seek(header.BitmapOffsetTableOffset, 0)
+	if err != nil {
+		t.Fatalf("Failed to seek to bitmap offset table: %v", err)
+	}
+
+	bitmapOffsets := make([]int64, header.BitmapCount)
+	err = binary.Read(reader, binary.LittleEndian, &bitmapOffsets)
+	if err != nil {
+		t.Fatalf("Failed to read bitmap offsets: %v", err)
+	}
+
+	// Read bitmaps using offset table
+	for i, offset := range bitmapOffsets {
+		_, err = reader.Seek(offset, 0)
+		if err != nil {
+			t.Fatalf("Failed to seek to bitmap %d: %v", i, err)
+		}
+
+		var width, height uint16
+		var size uint32
+		if err := binary.Read(reader, binary.LittleEndian, &width); err != nil {
+			t.Fatalf("Failed to read bitmap width: %v", err)
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &height); err != nil {
+			t.Fatalf("Failed to read bitmap height: %v", err)
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			t.Fatalf("Failed to read bitmap size: %v", err)
+		}
+
+		bitmapData := make([]byte, size)
+		_, err = reader.Read(bitmapData)
+		if err != nil {
+			t.Fatalf("Failed to read bitmap data: %v", err)
+		}
+
+		t.Logf("Bitmap %d: %dx%d, %d bytes", i, width, height, size)
+
+		// Validate bitmap data
+		if width != 5 || height != 10 {
+			t.Errorf("Bitmap dimensions mismatch: got %dx%d, want 5x10", width, height)
+		}
+	}
+
+	// Read audio offset table
+	_, err = reader.Seek(header.AudioOffsetTableOffset, 0)
+	if err != nil {
+		t.Fatalf("Failed to seek to audio offset table: %v", err)
+	}
+
+	audioOffsets := make([]int64, header.AudioCount)
+	err = binary.Read(reader, binary.LittleEndian, &audioOffsets)
+	if err != nil {
+		t.Fatalf("Failed to read audio offsets: %v", err)
+	}
+
+	// Read audio using offset table
+	// Note: Audio length comes from the node data, not the audio section
+	for i, offset := range audioOffsets {
+		_, err = reader.Seek(offset, 0)
+		if err != nil {
+			t.Fatalf("Failed to seek to audio %d: %v", i, err)
+		}
+
+		// For this test, we know the length is 3
+		audioBytes := make([]byte, 3)
+		_, err = reader.Read(audioBytes)
+		if err != nil {
+			t.Fatalf("Failed to read audio data: %v", err)
+		}
+
+		t.Logf("Audio %d: %d bytes, data=%v", i, len(audioBytes), audioBytes)
+
+		// Validate audio data
+		if !bytes.Equal(audioBytes, []byte{0xAA, 0xBB, 0xCC}) {
+			t.Errorf("Audio data mismatch: got %v, want [0xAA, 0xBB, 0xCC]", audioBytes)
+		}
+	}
+
+	t.Log("Successfully read back all data from NX file")
+}
+
+// BenchmarkWriteWithBuffering benchmarks writing with buffered I/O
+func BenchmarkWriteWithBuffering(b *testing.B) {
+	// Create a converter with test data
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+
+	// Add some test strings
+	for i := 0; i < 1000; i++ {
+		converter.addString(fmt.Sprintf("string_%d", i))
+	}
+
+	// Create a large node tree
+	root := &Node{
+		Name:     "",
+		Children: []*Node{},
+		Type:     NodeTypeNone,
+	}
+
+	for i := 0; i < 100; i++ {
+		child := &Node{
+			Name:     fmt.Sprintf("child_%d", i),
+			Children: []*Node{},
+			Type:     NodeTypeInt64,
+			Data:     int64(i),
+		}
+		root.Children = append(root.Children, child)
+	}
+
+	// Add test bitmaps with realistic sizes
+	for i := 0; i < 50; i++ {
+		bitmapData := make([]byte, 1024*10) // 10KB each
+		for j := range bitmapData {
+			bitmapData[j] = byte(j % 256)
+		}
+		bitmap := BitmapData{
+			Width:          100,
+			Height:         100,
+			Data:           bitmapData,
+			CompressedData: bitmapData[:len(bitmapData)/2], // Simulate compression
+		}
+		converter.bitmaps = append(converter.bitmaps, bitmap)
+	}
+
+	// Add test audio
+	for i := 0; i < 10; i++ {
+		audioData := make([]byte, 1024*50) // 50KB each
+		audio := AudioData{
+			Length:         uint32(len(audioData)),
+			Data:           audioData,
+			CompressedData: audioData,
+		}
+		converter.audio = append(converter.audio, audio)
+	}
+
+	converter.flattenNodes(root)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		// Use a temporary file
+		tmpFile := fmt.Sprintf("/tmp/bench_test_%d.nx", i)
+		converter.nxFilename = tmpFile
+		b.StartTimer()
+
+		// Write the file
+		if _, err := converter.writeNXFile(); err != nil {
+			b.Fatalf("Failed to write NX file: %v", err)
+		}
+
+		b.StopTimer()
+		// Clean up
+		os.Remove(tmpFile)
+		b.StartTimer()
+	}
+}
+
+// BenchmarkBufferedWrite benchmarks the write performance of the output buffer
+func BenchmarkBufferedWrite(b *testing.B) {
+	tmpFile := "/tmp/buffered_seeker_bench.dat"
+	defer os.Remove(tmpFile)
+
+	// Create test data
+	data := make([]byte, 1024) // 1KB
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+
+	b.Run("Buffered4MB", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			file, _ := os.Create(tmpFile)
+			bs := bufio.NewWriterSize(file, 4*1024*1024)
+			b.StartTimer()
+
+			// Write data many times
+			for j := 0; j < 1000; j++ {
+				if _, err := bs.Write(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := bs.Flush(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.StopTimer()
+			file.Close()
+			os.Remove(tmpFile)
+			b.StartTimer()
+		}
+	})
+
+	b.Run("Unbuffered", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			file, _ := os.Create(tmpFile)
+			b.StartTimer()
+
+			// Write data many times (unbuffered)
+			for j := 0; j < 1000; j++ {
+				if _, err := file.Write(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StopTimer()
+			file.Close()
+			os.Remove(tmpFile)
+			b.StartTimer()
+		}
+	})
+}
+
+// TestNXReaderRoundTrip writes an NX file and reads it back with the nx package
+func TestNXReaderRoundTrip(t *testing.T) {
+	converter := New("test.wz", "test.nx", Options{Mode: Client})
+	converter.addString("")
+
+	pixels := make([]byte, 2*3*4)
+	for i := range pixels {
+		pixels[i] = byte(i)
+	}
+	converter.bitmaps = append(converter.bitmaps, BitmapData{Width: 2, Height: 3, Data: pixels})
+
+	audioData := []byte{0x49, 0x44, 0x33, 0x04}
+	converter.audio = append(converter.audio, AudioData{Length: uint32(len(audioData)), Data: audioData})
+
+	info := &Node{Name: "info", Type: NodeTypeNone, Children: []*Node{
+		{Name: "bgm", Type: NodeTypeString, Data: "Bgm00/FloralLife"},
+		{Name: "version", Type: NodeTypeInt64, Data: int64(-10)},
+		{Name: "mobRate", Type: NodeTypeDouble, Data: float64(1.5)},
+	}}
+	canvas := &Node{Name: "0", Type: NodeTypeBitmap, Data: BitmapNodeData{ID: 0, Width: 2, Height: 3}, Children: []*Node{
+		{Name: "origin", Type: NodeTypePOINT, Data: [2]int32{-4, 7}},
+	}}
+	sound := &Node{Name: "sound", Type: NodeTypeAudio, Data: AudioNodeData{ID: 0, Length: uint32(len(audioData))}}
+	img := &Node{Name: "100000000.img", Type: NodeTypeNone, Children: []*Node{info, canvas, sound}}
+	root := &Node{Name: "", Type: NodeTypeNone, Children: []*Node{img}}
+	converter.flattenNodes(root)
+
+	buf := newSeekableBuffer()
+	if _, err := converter.writeNXData(buf); err != nil {
+		t.Fatalf("Failed to write NX data: %v", err)
+	}
+
+	file, err := nx.Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to load NX data: %v", err)
+	}
+
+	if bgm, ok := file.Resolve("100000000.img/info/bgm"); !ok {
+		t.Error("Could not resolve info/bgm")
+	} else if value, ok := bgm.Str(); !ok || value != "Bgm00/FloralLife" {
+		t.Errorf("bgm = %q, %v", value, ok)
+	}
+	if node, ok := file.Resolve("100000000.img/info/version"); !ok {
+		t.Error("Could not resolve info/version")
+	} else if value, ok := node.Int(); !ok || value != -10 {
+		t.Errorf("version = %d, %v", value, ok)
+	}
+	if node, ok := file.Resolve("100000000.img/info/mobRate"); !ok {
+		t.Error("Could not resolve info/mobRate")
+	} else if value, ok := node.Double(); !ok || value != 1.5 {
+		t.Errorf("mobRate = %f, %v", value, ok)
+	}
+	if node, ok := file.Resolve("100000000.img/0/origin"); !ok {
+		t.Error("Could not resolve 0/origin")
+	} else if x, y, ok := node.Point(); !ok || x != -4 || y != 7 {
+		t.Errorf("origin = (%d, %d), %v", x, y, ok)
+	}
+	if _, ok := file.Resolve("100000000.img/missing"); ok {
+		t.Error("Resolved a path that does not exist")
+	}
+
+	imgNode, _ := file.Resolve("100000000.img")
+	children := imgNode.Children()
+	if len(children) != 3 || children[0].Name() != "info" || children[2].Name() != "sound" {
+		t.Fatalf("Unexpected children of image node: %d", len(children))
+	}
+
+	decoded, err := children[1].Image()
+	if err != nil {
+		t.Fatalf("Failed to decode bitmap: %v", err)
+	}
+	if decoded.Bounds().Dx() != 2 || decoded.Bounds().Dy() != 3 {
+		t.Errorf("Bitmap bounds = %v, want 2x3", decoded.Bounds())
+	}
+	if c := color.NRGBAModel.Convert(decoded.At(1, 0)).(color.NRGBA); c != (color.NRGBA{R: 4, G: 5, B: 6, A: 7}) {
+		t.Errorf("Pixel (1,0) = %v, want {4 5 6 7}", c)
+	}
+
+	data, err := children[2].AudioData()
+	if err != nil {
+		t.Fatalf("Failed to read audio: %v", err)
+	}
+	if !bytes.Equal(data, audioData) {
+		t.Errorf("Audio data = %v, want %v", data, audioData)
+	}
+
+	if report := nx.Verify(buf.Bytes()); !report.Valid {
+		t.Errorf("Writer output failed verification: %+v", report.Problems)
+	}
+}
+
+// convertTestDirectory converts a WZ tree in memory and loads the result
+func convertTestDirectory(t *testing.T, dir *wz.WZDirectory, opts Options) (*nx.File, *Result) {
+	t.Helper()
+	buf := newSeekableBuffer()
+	result, err := ConvertDirectory(dir, buf, opts)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if report := nx.Verify(buf.Bytes()); !report.Valid {
+		t.Fatalf("Verify reported %+v", report.Problems)
+	}
+	file, err := nx.Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to load NX data: %v", err)
+	}
+	return file, result
+}
+
+func TestConvertOutputsFromOneParse(t *testing.T) {
+	opts := Options{Metadata: true, ServerDimensions: true}
+	write := func(c *Converter) []byte {
+		buf := newSeekableBuffer()
+		if _, err := c.writeNXData(buf); err != nil {
+			t.Fatalf("Failed to write NX data: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	parsed := New("test.wz", "test.nx", Options{Mode: Client, Metadata: true, ServerDimensions: true})
+	root := newRootNode()
+	parsed.traverseWZDirectory(wztest.BuildDirectory(), root)
+
+	// Every output must be identical to a separate conversion in its mode
+	for _, mode := range []Mode{Client, Server, Client} {
+		got := write(parsed.forOutput(Output{Filename: "out.nx", Mode: mode}, root))
+
+		opts.Mode = mode
+		want := newSeekableBuffer()
+		if _, err := ConvertDirectory(wztest.BuildDirectory(), want, opts); err != nil {
+			t.Fatalf("Failed to convert: %v", err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("Output in %s mode differs from a separate conversion (%d vs %d bytes)", mode, len(got), len(want.Bytes()))
+		}
+	}
+}
+
+func TestServerDimensionsSkipEmptyCanvases(t *testing.T) {
+	// Server outputs of a client parse and direct server conversions agree
+	// on empty canvases
+	build := func() *wz.WZDirectory {
+		dir := wztest.BuildDirectory()
+		img := dir.Directory("Mob").Image("100100.img")
+		stand := img.Properties.Get("stand")
+		variant := wztest.AddVariant(stand.Value.(*wz.WZProperty), stand.WZSimpleNode, "1", 9, nil)
+		variant.Value = wz.NewWZCanvas("1", variant.WZSimpleNode)
+		return dir
+	}
+	opts := Options{Mode: Server, ServerDimensions: true}
+
+	parsed := New("test.wz", "test.nx", Options{Mode: Client, ServerDimensions: true})
+	root := newRootNode()
+	parsed.traverseWZDirectory(build(), root)
+	got := newSeekableBuffer()
+	if _, err := parsed.forOutput(Output{Filename: "out.nx", Mode: Server}, root).writeNXData(got); err != nil {
+		t.Fatalf("Failed to write NX data: %v", err)
+	}
+	want := newSeekableBuffer()
+	if _, err := ConvertDirectory(build(), want, opts); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("Server output of a client parse differs from a server conversion")
+	}
+
+	file, _ := convertTestDirectory(t, build(), opts)
+	if _, ok := file.Root().Resolve("Mob/100100.img/stand/0/_width"); !ok {
+		t.Errorf("Canvas lacks _width")
+	}
+	if _, ok := file.Root().Resolve("Mob/100100.img/stand/1/_width"); ok {
+		t.Errorf("Empty canvas has _width")
+	}
+}
+
+func TestMergeRejectsDuplicateMountNames(t *testing.T) {
+	_, err := NewMerge([]string{"a/Map.wz", "b/Map.wz"}, "Data.nx", Options{Mode: Client}).Convert()
+	if err == nil || !strings.Contains(err.Error(), `mounted as "Map"`) {
+		t.Errorf("Expected a duplicate mount error, got %v", err)
+	}
+}
+
+func TestConvertSortsSiblings(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		file, _ := convertTestDirectory(t, wztest.BuildDirectory(), Options{Sort: sorted})
+		img, _ := file.Root().Resolve("Mob/100100.img")
+		var names []string
+		for _, child := range img.Children() {
+			names = append(names, child.Name())
+		}
+		want := []string{"info", "stand", "link", "foothold"}
+		if sorted {
+			want = []string{"foothold", "info", "link", "stand"}
+		}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("Sort=%v: children are %v, want %v", sorted, names, want)
+		}
+	}
+}
+
+func TestConvertUOLPolicies(t *testing.T) {
+	// A second link whose target does not exist
+	dir := wztest.BuildDirectory()
+	img := dir.Directory("Mob").Image("100100.img")
+	variant := wztest.AddVariant(img.Properties, img.WZSimpleNode, "broken", 9, nil)
+	broken := wz.NewWZUOL("broken", variant.WZSimpleNode)
+	broken.Reference = "../missing/0"
+	variant.Value = broken
+
+	for _, policy := range []UOLPolicy{UOLDrop, UOLString, UOLResolve} {
+		file, result := convertTestDirectory(t, dir, Options{Mode: Client, UOLs: policy})
+		link, _ := file.Root().Resolve("Mob/100100.img/link")
+		switch policy {
+		case UOLDrop:
+			if link.Type() != nx.TypeNone || len(result.Warnings) != 0 {
+				t.Errorf("drop: link type %s, warnings %q", link.Type(), result.Warnings)
+			}
+		case UOLString:
+			if ref, _ := link.Str(); ref != "stand/0" {
+				t.Errorf("string: link = %q", ref)
+			}
+		case UOLResolve:
+			frame, _ := file.Root().Resolve("Mob/100100.img/stand/0")
+			a, _ := frame.Bitmap()
+			b, ok := link.Bitmap()
+			if !ok || a.ID != b.ID {
+				t.Errorf("resolve: link is %s, want the bitmap of stand/0", link.Type())
+			}
+			if delay, ok := link.Child("delay"); !ok {
+				t.Errorf("resolve: link has no delay child")
+			} else if v, _ := delay.Int(); v != 120 {
+				t.Errorf("resolve: delay = %d", v)
+			}
+			if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "broken") {
+				t.Errorf("resolve: warnings %q", result.Warnings)
+			}
+			if node, _ := file.Root().Resolve("Mob/100100.img/broken"); node.Type() != nx.TypeNone {
+				t.Errorf("resolve: broken link is %s", node.Type())
+			}
+		}
+	}
+}
+
+func TestConvertLogsStructuredWarnings(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	img := dir.Directory("Mob").Image("100100.img")
+	variant := wztest.AddVariant(img.Properties, img.WZSimpleNode, "broken", 9, nil)
+	broken := wz.NewWZUOL("broken", variant.WZSimpleNode)
+	broken.Reference = "../missing/0"
+	variant.Value = broken
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	convertTestDirectory(t, dir, Options{Mode: Client, UOLs: UOLResolve, Logger: logger})
+
+	var warnings []map[string]any
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Failed to decode log record: %v", err)
+		}
+		if record["level"] == "WARN" {
+			warnings = append(warnings, record)
+		}
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("Got %d warning records, want 1", len(warnings))
+	}
+	if path, _ := warnings[0]["path"].(string); !strings.HasSuffix(path, "100100.img/broken") {
+		t.Errorf("Warning path = %q", path)
+	}
+	if warnings[0]["link"] != "../missing/0" {
+		t.Errorf("Warning link = %v", warnings[0]["link"])
+	}
+}
+
+func TestConvertReportsProgress(t *testing.T) {
+	// recordProgress checks that each stage starts, advances and ends in
+	// order and returns the stages in the order they started
+	recordProgress := func(t *testing.T) (*Options, func() ([]Stage, map[Stage]Progress)) {
+		var order []Stage
+		last := make(map[Stage]Progress)
+		opts := &Options{Progress: func(p Progress) {
+			prev, seen := last[p.Stage]
+			switch {
+			case p.Event == EventStart:
+				if seen {
+					t.Errorf("%s started twice", p.Stage)
+				}
+				order = append(order, p.Stage)
+			case !seen || prev.Event == EventEnd:
+				t.Errorf("%s %s outside of start and end", p.Stage, p.Event)
+			case p.Done < prev.Done:
+				t.Errorf("%s went back from %d to %d", p.Stage, prev.Done, p.Done)
+			}
+			last[p.Stage] = p
+		}}
+		return opts, func() ([]Stage, map[Stage]Progress) {
+			for stage, p := range last {
+				if p.Event != EventEnd || p.Done != p.Total {
+					t.Errorf("%s stopped with %s at %d of %d", stage, p.Event, p.Done, p.Total)
+				}
+			}
+			return order, last
+		}
+	}
+
+	opts, finish := recordProgress(t)
+	opts.Mode = Client
+	opts.Workers = 1
+	_, result := convertTestDirectory(t, wztest.BuildDirectory(), *opts)
+	order, last := finish()
+	if fmt.Sprint(order) != "[traverse compress write]" {
+		t.Errorf("Reported stages %v", order)
+	}
+	if p := last[StageTraverse]; p.Total != 2 {
+		t.Errorf("Traverse total = %d, want 2 images", p.Total)
+	}
+	if out := result.Outputs[0]; last[StageWrite].Total != out.Size || out.Bitmaps != 1 || out.Audio != 1 || out.Size == 0 {
+		t.Errorf("Unexpected result %+v, write ended at %+v", out, last[StageWrite])
+	}
+
+	// Converting a file also reports opening it
+	data := wztest.EncodeDirectories("Mob")
+	opts, finish = recordProgress(t)
+	opts.Outputs = []Output{{Filename: "Test.nx", Writer: io.Discard}}
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", "", *opts).Convert(); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	order, last = finish()
+	if fmt.Sprint(order) != "[parse traverse write]" {
+		t.Errorf("Reported stages %v", order)
+	}
+	if p := last[StageParse]; p.Total != 1 {
+		t.Errorf("Parse total = %d, want 1 file", p.Total)
+	}
+}
+
+func TestConvertFromReaderToWriter(t *testing.T) {
+	data := wztest.EncodeDirectories("Mob", "Npc", "Mob")
+
+	// The reader and the mapped file must parse alike
+	filename := t.TempDir() + "/Test.wz"
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := OpenWZFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to open mapped file: %v", err)
+	}
+	defer mapped.Close()
+	read, err := OpenWZReader(bytes.NewReader(data), int64(len(data)), "Test.wz")
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer read.Close()
+	if len(mapped.Root.Directories) != 3 || len(read.Root.Directories) != 3 {
+		t.Fatalf("Parsed %d and %d directories, want 3", len(mapped.Root.Directories), len(read.Root.Directories))
+	}
+	for i, dir := range mapped.Root.Directories {
+		if got := read.Root.Directories[i].Name; got != dir.Name {
+			t.Errorf("Directory %d is %q, want %q", i, got, dir.Name)
+		}
+	}
+
+	buf := newSeekableBuffer()
+	opts := Options{Outputs: []Output{{Filename: "Test.nx", Writer: buf}}}
+	result, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", "", opts).Convert()
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if _, err := os.Stat("Test.nx"); !os.IsNotExist(err) {
+		t.Errorf("Output with a writer created a file: %v", err)
+	}
+	if size := result.Outputs[0].Size; size != int64(len(buf.Bytes())) {
+		t.Errorf("Result size is %d, want %d", size, len(buf.Bytes()))
+	}
+	if report := nx.Verify(buf.Bytes()); !report.Valid {
+		t.Fatalf("Output failed verification: %+v", report.Problems)
+	}
+
+	file, err := nx.Load(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to load output: %v", err)
+	}
+	var names []string
+	for _, child := range file.Root().Children() {
+		names = append(names, child.Name())
+	}
+	if got := strings.Join(names, ","); got != "Mob,Npc,Mob" {
+		t.Errorf("Root children are %s, want Mob,Npc,Mob", got)
+	}
+}
+
+func TestConvertStreamsToNonSeekableWriter(t *testing.T) {
+	for _, opts := range []Options{{Mode: Client}, {Mode: Server, ServerDimensions: true}, {Mode: Client, DedupNodes: true}} {
+		var streamed bytes.Buffer
+		result, err := ConvertDirectory(wztest.BuildDirectory(), struct{ io.Writer }{&streamed}, opts)
+		if err != nil {
+			t.Fatalf("Failed to convert in %s mode: %v", opts.Mode, err)
+		}
+		if size := result.Outputs[0].Size; size != int64(streamed.Len()) {
+			t.Errorf("Result size is %d, want %d", size, streamed.Len())
+		}
+
+		want := newSeekableBuffer()
+		if _, err := ConvertDirectory(wztest.BuildDirectory(), want, opts); err != nil {
+			t.Fatalf("Failed to convert in %s mode: %v", opts.Mode, err)
+		}
+		if !bytes.Equal(streamed.Bytes(), want.Bytes()) {
+			t.Errorf("Streamed %s output differs from seekable output", opts.Mode)
+		}
+		if report := nx.Verify(streamed.Bytes()); !report.Valid {
+			t.Errorf("Streamed %s output failed verification: %+v", opts.Mode, report.Problems)
+		}
+	}
+}
+
+func TestConvertCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ConvertDirectoryContext(ctx, wztest.BuildDirectory(), newSeekableBuffer(), Options{Mode: Client}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Converting with a cancelled context returned %v, want context.Canceled", err)
+	}
+
+	// Cancelling while the file is written leaves nothing behind
+	dir := t.TempDir()
+	data := wztest.EncodeDirectories("Mob", "Npc")
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	opts := Options{Progress: func(p Progress) {
+		if p.Stage == StageWrite && p.Event == EventStart {
+			cancel()
+		}
+	}}
+	_, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", dir+"/Test.nx", opts).ConvertContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled conversion returned %v, want context.Canceled", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Cancelled conversion left %d file(s), e.g. %s", len(entries), entries[0].Name())
+	}
+
+	// A finished conversion leaves only the NX file
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", dir+"/Test.nx", Options{}).Convert(); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "Test.nx" {
+		t.Errorf("Conversion left %v, want only Test.nx", entries)
+	}
+}
+
+func TestConvertNoClobber(t *testing.T) {
+	dir := t.TempDir()
+	data := wztest.EncodeDirectories("Mob")
+	if err := os.WriteFile(dir+"/Test.nx", []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", dir+"/Test.nx", Options{NoClobber: true}).Convert()
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Converting over an existing file returned %v, want fs.ErrExist", err)
+	}
+	if got, _ := os.ReadFile(dir + "/Test.nx"); string(got) != "existing" {
+		t.Errorf("Existing file was replaced")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Conversion left %v, want only Test.nx", entries)
+	}
+
+	// A missing file is written
+	os.Remove(dir + "/Test.nx")
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", dir+"/Test.nx", Options{NoClobber: true}).Convert(); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "Test.nx" {
+		t.Errorf("Conversion left %v, want only Test.nx", entries)
+	}
+}
+
+func TestConvertFileMode(t *testing.T) {
+	// The output gets the permissions os.Create gives under the umask
+	dir := t.TempDir()
+	probe, err := os.Create(dir + "/probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+	want, err := os.Stat(dir + "/probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := wztest.EncodeDirectories("Mob")
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data)), "Test.wz", dir+"/Test.nx", Options{}).Convert(); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	got, err := os.Stat(dir + "/Test.nx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("Output mode is %v, want %v", got.Mode().Perm(), want.Mode().Perm())
+	}
+}
+
+func TestConvertErrorPolicies(t *testing.T) {
+	// An image without data to parse fails
+	dir := wztest.BuildDirectory()
+	dir.Directory("Mob").AddImage(wz.NewWZImage("Broken.img", dir.Directory("Mob").WZSimpleNode))
+
+	for _, policy := range []ErrorPolicy{ContinueOnError, BestEffort} {
+		file, result := convertTestDirectory(t, dir, Options{Mode: Client, Errors: policy})
+		if len(result.Failures) != 1 || !strings.HasSuffix(result.Failures[0].Path, "Mob/Broken.img") {
+			t.Errorf("%s: failures %v", policy, result.Failures)
+		}
+		// The failed image is missing from the output, the others are kept
+		if _, ok := file.Root().Resolve("Mob/Broken.img"); ok {
+			t.Errorf("%s: output holds the failed image", policy)
+		}
+		if _, ok := file.Root().Resolve("Mob/100100.img/info"); !ok {
+			t.Errorf("%s: output lacks Mob/100100.img/info", policy)
+		}
+	}
+	_, err := ConvertDirectory(dir, newSeekableBuffer(), Options{Mode: Client, Errors: FailFast})
+	var failure Failure
+	if !errors.As(err, &failure) || !strings.HasSuffix(failure.Path, "Mob/Broken.img") {
+		t.Errorf("fail-fast: returned %v, want the image failure", err)
+	}
+
+	// Only best effort leaves out merge inputs that fail to open
+	tmp := t.TempDir()
+	data := wztest.EncodeDirectories("Mob")
+	if err := os.WriteFile(tmp+"/Mob.wz", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{tmp + "/Missing.wz", tmp + "/Mob.wz"}
+	if _, err := NewMerge(inputs, tmp+"/Data.nx", Options{}).Convert(); err == nil {
+		t.Errorf("continue: merging a missing file succeeded")
+	}
+	result, err := NewMerge(inputs, tmp+"/Data.nx", Options{Errors: BestEffort}).Convert()
+	if err != nil {
+		t.Fatalf("best-effort: failed to merge: %v", err)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Path != inputs[0] {
+		t.Errorf("best-effort: failures %v", result.Failures)
+	}
+	file, err := nx.Open(tmp + "/Data.nx")
+	if err != nil {
+		t.Fatalf("Failed to open merged file: %v", err)
+	}
+	defer file.Close()
+	if _, ok := file.Root().Resolve("Mob/Mob"); !ok {
+		t.Errorf("best-effort: merged file lacks Mob/Mob")
+	}
+}
+
+func TestConvertBrokenCanvas(t *testing.T) {
+	// A canvas without pixel data fails and is written as an empty node
+	// that keeps its children, so the output still verifies
+	dir := wztest.BuildDirectory()
+	stand := dir.Directory("Mob").Image("100100.img").Properties.Get("stand").Value.(*wz.WZProperty)
+	stand.Get("0").Value.(*wz.WZCanvas).Data = nil
+
+	file, result := convertTestDirectory(t, dir, Options{Mode: Client, Errors: ContinueOnError})
+	if len(result.Failures) != 1 || !strings.Contains(result.Failures[0].Path, "Mob/100100.img/stand/0") {
+		t.Errorf("Failures %v, want the canvas", result.Failures)
+	}
+	node, ok := file.Root().Resolve("Mob/100100.img/stand/0")
+	if !ok || node.Type() != nx.TypeNone {
+		t.Fatalf("Broken canvas is %v, want an empty node", node.Type())
+	}
+	if _, ok := node.Resolve("origin"); !ok {
+		t.Errorf("Broken canvas lost its origin child")
+	}
+	if file.Header.BitmapCount != 0 {
+		t.Errorf("Output holds %d bitmaps, want none", file.Header.BitmapCount)
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	filter, err := NewPathFilter(
+		[]string{"Map/Map/Map1", `re:Sound/Bgm0[0-9]\.img`},
+		[]string{"Map/Map/Map1/*.img/back"},
+	)
+	if err != nil {
+		t.Fatalf("NewPathFilter failed: %v", err)
+	}
+
+	tests := []struct {
+		path         string
+		match, enter bool
+	}{
+		{"Map", false, true},
+		{"Map/Map/Map1", true, true},
+		{"Map/Map/Map1/100000000.img/info", true, true},
+		{"Map/Map/Map1/100000000.img/back", false, false},
+		{"Map/Map/Map1/100000000.img/back/0", false, false},
+		{"Map/Map/Map2", false, false},
+		{"Sound/Bgm00.img/Title", true, true},
+		{"Sound", false, true},
+		{"Sound/Bgm10.img", false, false},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.path); got != tt.match {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.match)
+		}
+		if got := filter.Enter(tt.path); got != tt.enter {
+			t.Errorf("Enter(%q) = %v, want %v", tt.path, got, tt.enter)
+		}
+	}
+
+	if _, err := NewPathFilter(nil, []string{"re:("}); err == nil {
+		t.Errorf("NewPathFilter accepted an invalid regular expression")
+	}
+	if got := filterPath("Map.wz/Map/Map1"); got != "Map/Map/Map1" {
+		t.Errorf("filterPath = %q", got)
+	}
+}
+
+func TestConvertFiltersPaths(t *testing.T) {
+	// Excluded images are never parsed, so the broken one does not fail
+	dir := wztest.BuildDirectory()
+	dir.Directory("Mob").AddImage(wz.NewWZImage("Broken.img", dir.Directory("Mob").WZSimpleNode))
+	filter, err := NewPathFilter([]string{"Test/Mob/*.img/info"}, []string{"Test/Mob/Broken.img"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	file, result := convertTestDirectory(t, dir, Options{
+		Filter: filter,
+		Progress: func(p Progress) {
+			if p.Stage == StageTraverse && p.Event == EventStart {
+				total = p.Total
+			}
+		},
+	})
+	if len(result.Failures) != 0 {
+		t.Errorf("Failures %v", result.Failures)
+	}
+	if total != 1 {
+		t.Errorf("Traversal counted %d images, want 1", total)
+	}
+
+	mob, _ := file.Root().Resolve("Mob")
+	if n := len(mob.Children()); n != 1 {
+		t.Errorf("Mob has %d children, want 1", n)
+	}
+	img, _ := file.Root().Resolve("Mob/100100.img")
+	var names []string
+	for _, child := range img.Children() {
+		names = append(names, child.Name())
+	}
+	if strings.Join(names, ",") != "info" {
+		t.Errorf("Image children are %v, want [info]", names)
+	}
+	if _, ok := file.Root().Resolve("Mob/100100.img/info/maxHP"); !ok {
+		t.Errorf("Output lacks the children of info")
+	}
+}
@@ -0,0 +1,165 @@
+package converter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// PathFilter selects nodes by path. Paths are separated by "/" and start
+// with the name of the WZ file without its extension, the name NewMerge
+// mounts the file under, as in "Map/Map/Map1/100000000.img/info".
+//
+// Patterns are globs in the syntax of path.Match, matched segment by
+// segment, or regular expressions prefixed with "re:", matched against the
+// whole path. A pattern that matches a node also matches everything below
+// it, so "Character/Afterimage" covers the whole directory.
+//
+// A node is selected when no include pattern is given or one matches it,
+// and no exclude pattern matches it. Nodes on the way to a selected node
+// are kept so that it stays reachable.
+type PathFilter struct {
+	include []pathPattern
+	exclude []pathPattern
+}
+
+// pathPattern is a compiled pattern, either a glob split into segments or
+// a regular expression
+type pathPattern struct {
+	segments []string
+	re       *regexp.Regexp
+}
+
+// NewPathFilter compiles include and exclude patterns into a filter
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	f := &PathFilter{}
+	var err error
+	if f.include, err = compilePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string) ([]pathPattern, error) {
+	compiled := make([]pathPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			compiled = append(compiled, pathPattern{re: re})
+			continue
+		}
+		glob := strings.Trim(pattern, "/")
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, pathPattern{segments: strings.Split(glob, "/")})
+	}
+	return compiled, nil
+}
+
+// matches reports whether the pattern matches p or one of its ancestors
+func (pp pathPattern) matches(p string) bool {
+	if pp.re != nil {
+		for {
+			if pp.re.MatchString(p) {
+				return true
+			}
+			i := strings.LastIndexByte(p, '/')
+			if i < 0 {
+				return false
+			}
+			p = p[:i]
+		}
+	}
+	segments := strings.Split(p, "/")
+	if len(pp.segments) > len(segments) {
+		return false
+	}
+	for i, glob := range pp.segments {
+		if ok, _ := path.Match(glob, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// leadsTo reports whether the pattern may match a descendant of p.
+// Regular expressions are only checked against their literal prefix.
+func (pp pathPattern) leadsTo(p string) bool {
+	if pp.re != nil {
+		prefix, _ := pp.re.LiteralPrefix()
+		return strings.HasPrefix(prefix, p+"/") || strings.HasPrefix(p+"/", prefix)
+	}
+	segments := strings.Split(p, "/")
+	if len(segments) >= len(pp.segments) {
+		return false
+	}
+	for i, segment := range segments {
+		if ok, _ := path.Match(pp.segments[i], segment); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether the node at p is selected. A nil filter selects
+// every node.
+func (f *PathFilter) Match(p string) bool {
+	if f == nil {
+		return true
+	}
+	for _, pattern := range f.exclude {
+		if pattern.matches(p) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if pattern.matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enter reports whether the node at p or a node below it may be selected,
+// which lets walks skip whole directories, images and subtrees
+func (f *PathFilter) Enter(p string) bool {
+	if f == nil || p == "" {
+		return true
+	}
+	if f.Match(p) {
+		return true
+	}
+	for _, pattern := range f.exclude {
+		if pattern.matches(p) {
+			return false
+		}
+	}
+	for _, pattern := range f.include {
+		if pattern.leadsTo(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPath returns the path of a WZ node as PathFilter sees it: its
+// GetPath with the extension of the file name removed
+func filterPath(wzPath string) string {
+	root, rest, found := strings.Cut(wzPath, "/")
+	root = strings.TrimSuffix(root, path.Ext(root))
+	if !found {
+		return root
+	}
+	return root + "/" + rest
+}
@@ -1,8 +1,9 @@
-package main
+package converter
 
 import (
 	"encoding/binary"
 	"fmt"
+	"image"
 
 	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
 )
@@ -113,6 +114,46 @@ func processCanvasData(canvas *wz.WZCanvas, data []byte) ([]byte, error) {
 	return processed, nil
 }
 
+// canvasFormatSupported reports whether processCanvasData decodes the
+// pixels of format1. Other formats decode to a blank bitmap.
+func canvasFormatSupported(format1 int32) bool {
+	switch format1 {
+	case 1, 2, 513:
+		return true
+	}
+	return false
+}
+
+// CanvasPixels decodes the pixels of a canvas to RGBA, as the converter
+// stores them in NX bitmaps
+func CanvasPixels(canvas *wz.WZCanvas) ([]byte, error) {
+	return processCanvasData(canvas, canvas.Data)
+}
+
+// DecodeCanvas decodes a canvas into an image using the same pipeline as
+// the converter
+func DecodeCanvas(canvas *wz.WZCanvas) (*image.NRGBA, error) {
+	pixels, err := processCanvasData(canvas, canvas.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := int(canvas.Width), int(canvas.Height)
+	if canvas.Format2 == 4 {
+		// processCanvasData scales these canvases by 16x
+		width, height = width*16, height*16
+	}
+	if len(pixels) != width*height*4 {
+		return nil, fmt.Errorf("decoded %d bytes, want %d for %dx%d", len(pixels), width*height*4, width, height)
+	}
+
+	return &image.NRGBA{
+		Pix:    pixels,
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}, nil
+}
+
 // convertARGB4444 converts ARGB4444 format to RGBA
 func convertARGB4444(data []byte, width, height int) ([]byte, error) {
 	pixels := width * height
@@ -0,0 +1,261 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Mode selects what an NX file contains
+type Mode int
+
+const (
+	// Server mode keeps the node tree and drops bitmaps and audio
+	Server Mode = iota
+	// Client mode also stores bitmaps and audio
+	Client
+)
+
+func (m Mode) String() string {
+	if m == Client {
+		return "client"
+	}
+	return "server"
+}
+
+// Compression selects the LZ4 level used for bitmaps
+type Compression int
+
+const (
+	LZ4 Compression = iota
+	LZ4HC
+)
+
+// UOLPolicy selects how UOL (link) properties are converted
+type UOLPolicy int
+
+const (
+	// UOLDrop writes UOLs as empty nodes
+	UOLDrop UOLPolicy = iota
+	// UOLString writes UOLs as string nodes holding the link path
+	UOLString
+	// UOLResolve replaces UOLs by a copy of the node they link to. Links
+	// that cannot be resolved are written as empty nodes and reported as
+	// warnings.
+	UOLResolve
+)
+
+// ParseUOLPolicy parses the name of a UOL policy: drop, string or resolve
+func ParseUOLPolicy(name string) (UOLPolicy, error) {
+	switch name {
+	case "drop":
+		return UOLDrop, nil
+	case "string":
+		return UOLString, nil
+	case "resolve":
+		return UOLResolve, nil
+	}
+	return UOLDrop, fmt.Errorf("unknown UOL policy %q (want drop, string or resolve)", name)
+}
+
+// ErrorPolicy selects what a conversion does when part of its input
+// cannot be converted: an image that fails to parse, a canvas that fails
+// to decode or, in a merge, a file that fails to open
+type ErrorPolicy int
+
+const (
+	// ContinueOnError skips images that fail and writes canvases that fail
+	// to decode as empty nodes that keep their children. It lists them in
+	// Result.Failures and writes the rest. A merge input that fails to
+	// open fails the conversion.
+	ContinueOnError ErrorPolicy = iota
+	// FailFast stops the conversion at the first failure and returns it as
+	// the error, leaving no output behind
+	FailFast
+	// BestEffort writes whatever can be converted. Like ContinueOnError it
+	// lists what failed in Result.Failures, and merge inputs that fail to
+	// open are skipped as well.
+	BestEffort
+)
+
+// ParseErrorPolicy parses the name of an error policy: fail-fast, continue
+// or best-effort
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	switch name {
+	case "fail-fast":
+		return FailFast, nil
+	case "continue":
+		return ContinueOnError, nil
+	case "best-effort":
+		return BestEffort, nil
+	}
+	return ContinueOnError, fmt.Errorf("unknown error policy %q (want fail-fast, continue or best-effort)", name)
+}
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case FailFast:
+		return "fail-fast"
+	case BestEffort:
+		return "best-effort"
+	}
+	return "continue"
+}
+
+// Stage names a step of a conversion reported through Options.Progress.
+// Each stage counts its own unit in Progress.Done and Progress.Total.
+type Stage string
+
+const (
+	StageParse    Stage = "parse"    // WZ files opened and their directories read, in files
+	StageTraverse Stage = "traverse" // images parsed into nodes, in images
+	StageCompress Stage = "compress" // bitmaps compressed, in bitmaps
+	StageWrite    Stage = "write"    // NX file written, in bytes
+)
+
+// Event is the kind of a progress report
+type Event string
+
+const (
+	EventStart    Event = "start"    // the stage began, Total is known
+	EventProgress Event = "progress" // Done advanced by at least a percent
+	EventEnd      Event = "end"      // the stage finished
+)
+
+// Progress reports how far a stage has come. Every stage that runs reports
+// a start and an end event, with progress events in between. Parsing and
+// traversal run once per conversion; compression and writing run for each
+// output, and compression only when it has bitmaps to compress.
+type Progress struct {
+	Event  Event
+	Stage  Stage
+	Output string // output filename, empty while parsing and traversing
+	Done   int64
+	Total  int64
+}
+
+// Output is one NX file written by a conversion
+type Output struct {
+	Filename string
+	Mode     Mode
+
+	// Writer, if set, receives the file instead of Filename, which then
+	// only names the output in messages and results. The file is written
+	// sequentially, so Writer may be a pipe, a compressor or stdout.
+	Writer io.Writer
+}
+
+// Options configures a conversion. The zero value converts in server
+// mode with LZ4 compression, WZ node order, dropped UOLs, one worker per
+// CPU, failed images skipped and no logging.
+type Options struct {
+	Mode        Mode
+	Compression Compression
+
+	// Sort orders siblings by name like the original C++ tool instead of
+	// keeping the WZ order
+	Sort bool
+	UOLs UOLPolicy
+	// Errors selects what happens when part of the input fails to convert
+	Errors ErrorPolicy
+	// Filter, if set, selects the nodes to convert. Directories and images
+	// it rejects are skipped without being parsed.
+	Filter *PathFilter
+
+	// Workers bounds the images parsed and bitmaps compressed at once.
+	// Zero uses one worker per CPU.
+	Workers int
+
+	// Logger receives progress messages at info level, warnings, and
+	// detailed records of the converter and the WZ parser at debug level.
+	// Records carry the input and output files and, where known, the node
+	// path and file offset. Nil discards them.
+	Logger *slog.Logger
+	// Progress, if set, is called as each stage starts, advances and ends.
+	// Calls are not concurrent.
+	Progress func(Progress)
+
+	// Outputs lists the files written from a single parse. When empty, one
+	// file is written in Mode.
+	Outputs []Output
+	// NoClobber fails an output whose file already exists instead of
+	// replacing it. The check is atomic with moving the finished file into
+	// place, so a file created during the conversion is kept as well.
+	NoClobber bool
+
+	// Metadata attaches reserved child nodes (_format, _playtime, ...)
+	// describing the source of canvases and sounds
+	Metadata bool
+	// ServerDimensions keeps the size of canvases in server mode as
+	// _width and _height child nodes. Empty canvases, which have no bitmap
+	// in client mode either, get none.
+	ServerDimensions bool
+	// NoDedup stores identical bitmaps and audio separately
+	NoDedup bool
+	// DedupNodes lets nodes with identical subtrees share one child range
+	DedupNodes bool
+}
+
+// Result describes a finished conversion
+type Result struct {
+	Outputs []OutputResult
+	Dedup   DedupStats
+	// Failures lists the parts of the input that could not be converted:
+	// images missing from the output and canvases written without their
+	// bitmap
+	Failures []Failure
+	// Warnings lists problems that did not lose data outright, such as
+	// UOLs that could not be resolved or canvases in formats that are
+	// stored blank
+	Warnings []string
+}
+
+// Failure is a part of the input that could not be converted
+type Failure struct {
+	// Path is the WZ path of the failed image or canvas, or the file name
+	// of a merge input that failed to open
+	Path string
+	Err  error
+}
+
+func (f Failure) Error() string {
+	return f.Path + ": " + f.Err.Error()
+}
+
+func (f Failure) Unwrap() error {
+	return f.Err
+}
+
+// OutputResult describes one written NX file
+type OutputResult struct {
+	Filename string
+	Mode     Mode
+	Size     int64 // bytes written
+
+	Nodes   int
+	Strings int
+	Bitmaps int
+	Audio   int
+
+	// TreeNodes counts the nodes before shared subtrees were merged. It
+	// equals Nodes unless DedupNodes is set.
+	TreeNodes int
+}
+
+// DedupStats counts the bitmaps and audio entries that were deduplicated
+// and the uncompressed bytes they would have taken
+type DedupStats struct {
+	Bitmaps     int
+	BitmapBytes uint64
+	Audio       int
+	AudioBytes  uint64
+}
+
+// discardHandler drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
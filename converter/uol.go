@@ -0,0 +1,125 @@
+package converter
+
+import (
+	"strings"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// UOL resolution states
+const (
+	uolPending = iota
+	uolResolving
+	uolDone
+)
+
+// uolRef is a UOL node waiting to be resolved
+type uolRef struct {
+	parent    *Node // the link path is relative to the UOL's parent
+	reference string
+	path      string // WZ path of the UOL, for warnings
+	state     int
+}
+
+// traverseWZUOL converts a UOL (link) according to the UOL policy
+func (c *Converter) traverseWZUOL(uol *wz.WZUOL, path string, node, parentNode *Node) {
+	node.Type = NodeTypeNone
+	switch c.opts.UOLs {
+	case UOLString:
+		node.Type = NodeTypeString
+		node.Data = uol.Reference
+	case UOLResolve:
+		// Targets may lie in images that are still being traversed
+		c.mu.Lock()
+		if c.uols == nil {
+			c.uols = make(map[*Node]*uolRef)
+		}
+		c.uols[node] = &uolRef{parent: parentNode, reference: uol.Reference, path: path}
+		c.mu.Unlock()
+	}
+}
+
+// resolveUOLs replaces every pending UOL node by a copy of the node it
+// links to, in tree order
+func (c *Converter) resolveUOLs(root *Node) {
+	parents := make(map[*Node]*Node)
+	var index func(node *Node)
+	index = func(node *Node) {
+		for _, child := range node.Children {
+			parents[child] = node
+			index(child)
+		}
+	}
+	index(root)
+
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if ref, ok := c.uols[node]; ok {
+			c.resolveUOL(node, ref, parents)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	c.uols = nil
+}
+
+// resolveUOL gives a UOL node the type, value and a copy of the children
+// of its target. Unresolvable links stay empty nodes.
+func (c *Converter) resolveUOL(node *Node, ref *uolRef, parents map[*Node]*Node) {
+	switch ref.state {
+	case uolDone:
+		return
+	case uolResolving:
+		c.warn("UOL is part of a link cycle", "path", ref.path)
+		return
+	}
+	ref.state = uolResolving
+	defer func() { ref.state = uolDone }()
+
+	target := ref.parent
+	for _, part := range strings.Split(ref.reference, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			target = parents[target]
+		default:
+			target = childNamed(target, part)
+		}
+		if target == nil {
+			c.warn("UOL links to missing node", "path", ref.path, "link", ref.reference)
+			return
+		}
+	}
+
+	copied := c.copyResolved(target, parents)
+	node.Type, node.Data, node.Children = copied.Type, copied.Data, copied.Children
+}
+
+// copyResolved copies a subtree, resolving the UOLs in it first
+func (c *Converter) copyResolved(node *Node, parents map[*Node]*Node) *Node {
+	if ref, ok := c.uols[node]; ok {
+		c.resolveUOL(node, ref, parents)
+	}
+	copied := &Node{
+		Name:     node.Name,
+		Children: make([]*Node, 0, len(node.Children)),
+		Type:     node.Type,
+		Data:     node.Data,
+	}
+	for _, child := range node.Children {
+		copied.Children = append(copied.Children, c.copyResolved(child, parents))
+	}
+	return copied
+}
+
+// childNamed returns the first child of node with the given name
+func childNamed(node *Node, name string) *Node {
+	for _, child := range node.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
@@ -0,0 +1,517 @@
+package converter
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// Reserved names of the metadata child nodes attached to canvases and sounds
+// when metadata is enabled. Their values are int64 nodes.
+const (
+	MetadataFormat        = "_format"        // canvas Format1, or sound wave format tag
+	MetadataFormat2       = "_format2"       // canvas Format2
+	MetadataMagLevel      = "_maglevel"      // canvas MagLevel
+	MetadataPlaytime      = "_playtime"      // sound playtime in milliseconds
+	MetadataSampleRate    = "_samplerate"    // sound samples per second
+	MetadataChannels      = "_channels"      // sound channel count
+	MetadataBitsPerSample = "_bitspersample" // sound bits per sample
+	MetadataWidth         = "_width"         // canvas width in server mode
+	MetadataHeight        = "_height"        // canvas height in server mode
+)
+
+// metadataNames holds every reserved metadata child name
+var metadataNames = map[string]bool{
+	MetadataFormat:        true,
+	MetadataFormat2:       true,
+	MetadataMagLevel:      true,
+	MetadataPlaytime:      true,
+	MetadataSampleRate:    true,
+	MetadataChannels:      true,
+	MetadataBitsPerSample: true,
+	MetadataWidth:         true,
+	MetadataHeight:        true,
+}
+
+// IsMetadata reports whether name is a reserved metadata child name
+func IsMetadata(name string) bool {
+	return metadataNames[name]
+}
+
+// OpenWZFile opens a WZ file and parses its directory structure.
+// Images are loaded lazily when they are first traversed.
+func OpenWZFile(filename string) (wzFile *wz.WZFile, err error) {
+	wzFile, err = wz.NewFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening WZ file: %w", err)
+	}
+	return loadWZFile(wzFile)
+}
+
+// OpenWZReader parses the directory structure of a WZ file of size bytes
+// read from r. name is used as the file name. r must stay readable until
+// the file is closed.
+func OpenWZReader(r io.ReaderAt, size int64, name string) (*wz.WZFile, error) {
+	return loadWZFile(wz.NewFileFromReader(r, size, name))
+}
+
+// loadWZFile parses the directory structure of an opened WZ file
+func loadWZFile(opened *wz.WZFile) (wzFile *wz.WZFile, err error) {
+	// The wz package reports malformed input by panicking
+	defer func() {
+		if r := recover(); r != nil {
+			opened.Close()
+			wzFile, err = nil, fmt.Errorf("parsing WZ file: %v", r)
+		}
+	}()
+
+	opened.Parse()
+	opened.WaitUntilLoaded()
+	return opened, nil
+}
+
+// parseInputs opens the WZ files of the conversion and parses them into a
+// node tree. Merged files are mounted under a top-level node named after
+// the file without its extension.
+func (c *Converter) parseInputs() (*Node, error) {
+	filenames := c.mergeInputs
+	var names []string
+	if len(filenames) > 0 {
+		// Check the mount names before parsing anything
+		names = make([]string, len(filenames))
+		mounted := make(map[string]string)
+		for i, filename := range filenames {
+			names[i] = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+			if other, exists := mounted[names[i]]; exists {
+				return nil, fmt.Errorf("%s and %s would both be mounted as %q", other, filename, names[i])
+			}
+			mounted[names[i]] = filename
+		}
+	} else {
+		filenames = []string{c.wzFilename}
+	}
+
+	// Open every file and read its directories. Under BestEffort, merge
+	// inputs that fail to open are left out.
+	files := make([]*wz.WZFile, 0, len(filenames))
+	var mounts []string
+	defer func() {
+		for _, wzFile := range files {
+			wzFile.Close()
+		}
+	}()
+	total := int64(len(filenames))
+	c.progress(EventStart, StageParse, "", 0, total)
+	for i, filename := range filenames {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+		var opened, wzFile *wz.WZFile
+		var err error
+		if c.input != nil {
+			opened = wz.NewFileFromReader(c.input.r, c.input.size, c.input.name)
+		} else if opened, err = wz.NewFile(filename); err != nil {
+			err = fmt.Errorf("opening WZ file: %w", err)
+		}
+		if err == nil {
+			logger := c.log
+			if names != nil {
+				logger = logger.With("input", filename)
+			}
+			opened.SetLogger(logger)
+			wzFile, err = loadWZFile(opened)
+		}
+		if err != nil {
+			if names == nil {
+				return nil, err
+			}
+			if c.opts.Errors != BestEffort {
+				return nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			c.fail(filename, err)
+			continue
+		}
+		files = append(files, wzFile)
+		if names != nil {
+			mounts = append(mounts, names[i])
+		}
+		if wzFile.Root != nil {
+			c.imagesTotal += c.countImages(wzFile.Root)
+		}
+		c.progress(EventProgress, StageParse, "", int64(i+1), total)
+	}
+	c.progress(EventEnd, StageParse, "", total, total)
+
+	// Traverse the images of all files
+	root := newRootNode()
+	c.progress(EventStart, StageTraverse, "", 0, int64(c.imagesTotal))
+	for i, wzFile := range files {
+		parent := root
+		if names != nil {
+			parent = &Node{
+				Name:     mounts[i],
+				Children: []*Node{},
+				Type:     NodeTypeNone,
+			}
+			root.Children = append(root.Children, parent)
+		}
+		if wzFile.Root != nil {
+			c.traverseWZDirectory(wzFile.Root, parent)
+		}
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	c.progress(EventEnd, StageTraverse, "", c.imagesDone.Load(), int64(c.imagesTotal))
+	return root, nil
+}
+
+// countImages counts the images of a directory tree that pass the filter
+func (c *Converter) countImages(dir *wz.WZDirectory) int {
+	count := 0
+	for _, img := range dir.Images {
+		if c.enter(img.WZSimpleNode) {
+			count++
+		}
+	}
+	for _, sub := range dir.Directories {
+		if c.enter(sub.WZSimpleNode) {
+			count += c.countImages(sub)
+		}
+	}
+	return count
+}
+
+// enter reports whether the filter lets a node or a node below it into
+// the output. The path of the node is only built when there is a filter.
+func (c *Converter) enter(node *wz.WZSimpleNode) bool {
+	return c.opts.Filter == nil || c.opts.Filter.Enter(filterPath(node.GetPath()))
+}
+
+// traverseWZDirectory recursively traverses WZ directories. Directories
+// and images rejected by the filter are skipped before anything is parsed.
+// Once the conversion is cancelled, no more images are started and the tree
+// is left incomplete.
+func (c *Converter) traverseWZDirectory(wzDir *wz.WZDirectory, parentNode *Node) {
+	// Process subdirectories in order
+	for _, dir := range wzDir.Directories {
+		if c.ctx.Err() != nil {
+			return
+		}
+		if !c.enter(dir.WZSimpleNode) {
+			continue
+		}
+		childNode := &Node{
+			Name:     dir.Name,
+			Children: []*Node{},
+			Type:     NodeTypeNone,
+		}
+		parentNode.Children = append(parentNode.Children, childNode)
+		c.traverseWZDirectory(dir, childNode)
+	}
+
+	images := wzDir.Images
+	if c.opts.Filter != nil {
+		images = make([]*wz.WZImage, 0, len(wzDir.Images))
+		for _, img := range wzDir.Images {
+			if c.enter(img.WZSimpleNode) {
+				images = append(images, img)
+			}
+		}
+	}
+
+	// Process images in parallel for better performance
+	// Since images are independent, we can parse them concurrently, up to
+	// one per worker
+	if len(images) > 0 {
+		semaphore := make(chan struct{}, c.workers())
+		// Create a slice to hold child nodes in order
+		imageNodes := make([]*Node, len(images))
+		var wg sync.WaitGroup
+
+	schedule:
+		for i, img := range images {
+			imageNodes[i] = &Node{
+				Name:     img.Name,
+				Children: []*Node{},
+				Type:     NodeTypeNone,
+			}
+
+			if c.ctx.Err() != nil {
+				break
+			}
+			select {
+			case semaphore <- struct{}{}:
+			case <-c.ctx.Done():
+				break schedule
+			}
+			wg.Add(1)
+			// Capture loop variables
+			i, img := i, img
+			node := imageNodes[i]
+
+			go func() {
+				// Deferred calls run last to first, so the image is
+				// fully accounted for before Done
+				defer wg.Done()
+				defer c.imageDone()
+				defer func() {
+					if r := recover(); r != nil {
+						// A failed image is left out rather than
+						// written half converted
+						imageNodes[i] = nil
+						c.fail(img.GetPath(), fmt.Errorf("processing image: %v", r))
+					}
+				}()
+				defer func() { <-semaphore }()
+				// Use ParseWithCopy for thread-safe parallel processing
+				// Each goroutine gets its own bytes.Reader copy
+				img.ParseWithCopy()
+				c.traverseWZImage(img, node)
+			}()
+		}
+
+		// Wait for all images to be processed
+		wg.Wait()
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		// Append nodes in order after parallel processing
+		for _, node := range imageNodes {
+			if node != nil {
+				parentNode.Children = append(parentNode.Children, node)
+			}
+		}
+	}
+}
+
+// imageDone reports a traversed image to the progress hook
+func (c *Converter) imageDone() {
+	c.progress(EventProgress, StageTraverse, "", c.imagesDone.Add(1), int64(c.imagesTotal))
+}
+
+// traverseWZImage processes a WZ image
+func (c *Converter) traverseWZImage(wzImg *wz.WZImage, parentNode *Node) {
+	wzImg.StartParse()
+
+	if wzImg.Properties != nil {
+		c.log.Debug("processing image", "path", wzImg.GetPath(), "properties", len(wzImg.Properties.Entries))
+		for idx, prop := range wzImg.Properties.Entries {
+			c.log.Debug("property", "path", wzImg.GetPath(), "index", idx, "name", prop.Name, "type", prop.Type)
+			c.traverseWZVariant(prop.Name, prop, parentNode)
+		}
+	}
+}
+
+// traverseWZVariant processes a WZ variant
+func (c *Converter) traverseWZVariant(name string, variant *wz.WZVariant, parentNode *Node) {
+	if !c.enter(variant.WZSimpleNode) {
+		return
+	}
+	node := &Node{
+		Name:     name,
+		Children: []*Node{},
+	}
+
+	switch variant.Type {
+	case 0: // None
+		node.Type = NodeTypeNone
+		node.Data = nil
+
+	case 2, 11: // int16
+		node.Type = NodeTypeInt64
+		if val, ok := variant.Value.(int16); ok {
+			node.Data = int64(val)
+		}
+
+	case 3, 19: // int32
+		node.Type = NodeTypeInt64
+		if val, ok := variant.Value.(int32); ok {
+			node.Data = int64(val)
+		}
+
+	case 20: // int64
+		node.Type = NodeTypeInt64
+		if val, ok := variant.Value.(int64); ok {
+			node.Data = val
+		}
+
+	case 4: // float32
+		node.Type = NodeTypeDouble
+		if val, ok := variant.Value.(float32); ok {
+			node.Data = float64(val)
+		}
+
+	case 5: // float64
+		node.Type = NodeTypeDouble
+		if val, ok := variant.Value.(float64); ok {
+			node.Data = val
+		}
+
+	case 8: // String
+		node.Type = NodeTypeString
+		if val, ok := variant.Value.(string); ok {
+			node.Data = val
+		}
+
+	case 9: // Sub object
+		if uol, ok := variant.Value.(*wz.WZUOL); ok {
+			c.traverseWZUOL(uol, variant.GetPath(), node, parentNode)
+		} else {
+			c.traverseWZObject(variant.Value, node)
+		}
+
+	default:
+		node.Type = NodeTypeNone
+		node.Data = nil
+	}
+
+	parentNode.Children = append(parentNode.Children, node)
+}
+
+// traverseWZObject processes a WZ object (Canvas, Vector, Sound, etc.)
+func (c *Converter) traverseWZObject(obj interface{}, parentNode *Node) {
+	switch v := obj.(type) {
+	case *wz.WZCanvas:
+		c.traverseWZCanvas(v, parentNode)
+
+	case *wz.WZVector:
+		parentNode.Type = NodeTypePOINT
+		parentNode.Data = [2]int32{v.X, v.Y}
+
+	case *wz.WZSoundDX8:
+		if c.client {
+			c.traverseWZSound(v, parentNode)
+		} else {
+			parentNode.Type = NodeTypeNone
+		}
+		if c.opts.Metadata {
+			addMetadata(parentNode, MetadataPlaytime, int64(v.Playtime))
+			if len(v.WaveFormat) > 0 {
+				addMetadata(parentNode, MetadataFormat, int64(v.FormatTag))
+				addMetadata(parentNode, MetadataSampleRate, int64(v.SampleRate))
+				addMetadata(parentNode, MetadataChannels, int64(v.Channels))
+				addMetadata(parentNode, MetadataBitsPerSample, int64(v.BitsPerSample))
+			}
+		}
+
+	case *wz.WZProperty:
+		parentNode.Type = NodeTypeNone
+		for _, prop := range v.Entries {
+			c.traverseWZVariant(prop.Name, prop, parentNode)
+		}
+
+	default:
+		parentNode.Type = NodeTypeNone
+	}
+}
+
+// traverseWZCanvas processes a Canvas (bitmap image)
+func (c *Converter) traverseWZCanvas(canvas *wz.WZCanvas, parentNode *Node) {
+	// Process canvas properties first
+	if canvas.Properties != nil {
+		for _, prop := range canvas.Properties.Entries {
+			c.traverseWZVariant(prop.Name, prop, parentNode)
+		}
+	}
+
+	if c.opts.Metadata {
+		addMetadata(parentNode, MetadataFormat, int64(canvas.Format1))
+		addMetadata(parentNode, MetadataFormat2, int64(canvas.Format2))
+		addMetadata(parentNode, MetadataMagLevel, int64(canvas.MagLevel))
+	}
+
+	// In client mode, handle bitmap data. A canvas whose pixels cannot be
+	// extracted becomes an empty node that keeps its children.
+	var data []byte
+	if c.client && canvas.Width > 0 && canvas.Height > 0 {
+		data = c.extractCanvasData(canvas)
+	}
+	if data != nil {
+		width := uint16(canvas.Width)
+		height := uint16(canvas.Height)
+
+		bitmap := BitmapData{
+			Width:  width,
+			Height: height,
+			Data:   data,
+		}
+		bitmapID := c.addBitmap(bitmap)
+
+		parentNode.Type = NodeTypeBitmap
+		parentNode.Data = BitmapNodeData{
+			ID:     bitmapID,
+			Width:  width,
+			Height: height,
+		}
+	} else {
+		parentNode.Type = NodeTypeNone
+		if !c.client && c.opts.ServerDimensions && canvas.Width > 0 && canvas.Height > 0 {
+			// Server mode has no bitmaps, so the size is kept as children.
+			// Like serverTree, only canvases that would hold a bitmap in
+			// client mode get them.
+			addMetadata(parentNode, MetadataWidth, int64(canvas.Width))
+			addMetadata(parentNode, MetadataHeight, int64(canvas.Height))
+		}
+	}
+}
+
+// extractCanvasData extracts and decompresses canvas pixel data. A canvas
+// without data or whose data fails to decode is reported as a failure and
+// yields nil.
+func (c *Converter) extractCanvasData(canvas *wz.WZCanvas) []byte {
+	// Get the canvas data using exported Data field
+	rawData := canvas.Data
+
+	if len(rawData) == 0 {
+		c.fail(canvas.GetPath(), fmt.Errorf("canvas of %dx%d has no pixel data", canvas.Width, canvas.Height))
+		return nil
+	}
+
+	// Process the canvas data based on its format
+	processedData, err := processCanvasData(canvas, rawData)
+	if err != nil {
+		// Record the error but don't fail completely
+		c.fail(canvas.GetPath(), fmt.Errorf("processing canvas data: %w", err))
+		return nil
+	}
+	if !canvasFormatSupported(canvas.Format1) {
+		c.warn("unsupported canvas format, stored blank", "path", canvas.GetPath(), "format", canvas.Format1)
+	}
+
+	return processedData
+}
+
+// traverseWZSound processes a Sound object
+func (c *Converter) traverseWZSound(sound *wz.WZSoundDX8, parentNode *Node) {
+	// Use exported SoundData field directly
+	soundData := sound.SoundData
+	length := uint32(len(soundData))
+
+	audio := AudioData{
+		Length: length,
+		Data:   soundData,
+	}
+	audioID := c.addAudio(audio)
+
+	parentNode.Type = NodeTypeAudio
+	parentNode.Data = AudioNodeData{
+		ID:     audioID,
+		Length: length,
+	}
+}
+
+// addMetadata appends a reserved int64 child node
+func addMetadata(node *Node, name string, value int64) {
+	node.Children = append(node.Children, &Node{
+		Name:     name,
+		Children: []*Node{},
+		Type:     NodeTypeInt64,
+		Data:     value,
+	})
+}
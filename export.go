@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// mediaMode selects how bitmaps and audio are exported
+type mediaMode string
+
+const (
+	mediaOmit   mediaMode = "omit"
+	mediaBase64 mediaMode = "base64"
+	mediaFile   mediaMode = "file"
+)
+
+// parseMediaMode validates a media mode flag value
+func parseMediaMode(value string) (mediaMode, error) {
+	switch mode := mediaMode(value); mode {
+	case mediaOmit, mediaBase64, mediaFile:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid media mode %q (want omit, base64 or file)", value)
+	}
+}
+
+// exportOptions controls how trees are exported
+type exportOptions struct {
+	// Bitmaps selects how canvas pixels are exported: omitted, embedded
+	// as base64 PNG or written to PNG files referenced by path
+	Bitmaps mediaMode
+	// Audio selects how sound data is exported
+	Audio mediaMode
+	// MediaDir receives bitmap and audio files in file mode. Paths in the
+	// output are relative to it.
+	MediaDir string
+	// Pretty indents the output
+	Pretty bool
+	// Failed, if set, is called for each bitmap that cannot be decoded.
+	// The export leaves its pixels out and goes on.
+	Failed func(err error)
+}
+
+// failed reports media that is left out of an export
+func (o exportOptions) failed(err error) {
+	if o.Failed != nil {
+		o.Failed(err)
+	}
+}
+
+// jsonWriter streams a JSON document without holding it in memory.
+// The first write error is kept and reported by Flush.
+type jsonWriter struct {
+	w        *bufio.Writer
+	pretty   bool
+	depth    int
+	first    bool
+	afterKey bool
+	err      error
+
+	// keys holds the keys of the open objects by depth, so that siblings
+	// with the same name get keys of their own
+	keys []keySet
+	// scratch is reused to escape strings
+	scratch []byte
+}
+
+// keySet holds the keys written to an open object. Small objects are
+// searched in order; large ones get an index.
+type keySet struct {
+	list  []string
+	index map[string]struct{}
+}
+
+// reset empties the set for the next object at its depth
+func (s *keySet) reset() {
+	s.list = s.list[:0]
+	s.index = nil
+}
+
+// has reports whether key was written
+func (s *keySet) has(key string) bool {
+	if s.index != nil {
+		_, ok := s.index[key]
+		return ok
+	}
+	for _, k := range s.list {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// add records a written key
+func (s *keySet) add(key string) {
+	if s.index != nil {
+		s.index[key] = struct{}{}
+		return
+	}
+	s.list = append(s.list, key)
+	if len(s.list) > 16 {
+		s.index = make(map[string]struct{}, 2*len(s.list))
+		for _, k := range s.list {
+			s.index[k] = struct{}{}
+		}
+	}
+}
+
+// newJSONWriter creates a streaming JSON writer
+func newJSONWriter(w io.Writer, pretty bool) *jsonWriter {
+	return &jsonWriter{w: bufio.NewWriterSize(w, converter.BufferSizeMB*1024*1024), pretty: pretty, first: true}
+}
+
+// write writes raw output unless an earlier write failed
+func (j *jsonWriter) write(s string) {
+	if j.err == nil {
+		_, j.err = j.w.WriteString(s)
+	}
+}
+
+// newline starts a new indented line in pretty mode
+func (j *jsonWriter) newline() {
+	if j.pretty {
+		j.write("\n" + strings.Repeat("  ", j.depth))
+	}
+}
+
+// elem writes the separator that precedes an array element or object key
+func (j *jsonWriter) elem() {
+	if j.afterKey {
+		j.afterKey = false
+		return
+	}
+	if !j.first {
+		j.write(",")
+	}
+	j.first = false
+	if j.depth > 0 {
+		j.newline()
+	}
+}
+
+// beginObject opens an object
+func (j *jsonWriter) beginObject() {
+	j.elem()
+	j.write("{")
+	j.depth++
+	j.first = true
+	j.openKeys().reset()
+}
+
+// openKeys returns the keys of the innermost open object
+func (j *jsonWriter) openKeys() *keySet {
+	for len(j.keys) <= j.depth {
+		j.keys = append(j.keys, keySet{})
+	}
+	return &j.keys[j.depth]
+}
+
+// endObject closes an object
+func (j *jsonWriter) endObject() {
+	j.depth--
+	if !j.first {
+		j.newline()
+	}
+	j.write("}")
+	j.first = false
+}
+
+// beginArray opens an array
+func (j *jsonWriter) beginArray() {
+	j.elem()
+	j.write("[")
+	j.depth++
+	j.first = true
+}
+
+// endArray closes an array
+func (j *jsonWriter) endArray() {
+	j.depth--
+	if !j.first {
+		j.newline()
+	}
+	j.write("]")
+	j.first = false
+}
+
+// key writes an object key; the next call must write its value. A name
+// the object already has gets a numeric suffix, as in "0_2", so that
+// siblings with the same name are all kept.
+func (j *jsonWriter) key(name string) {
+	keys := j.openKeys()
+	if keys.has(name) {
+		for n := 2; ; n++ {
+			if candidate := name + "_" + strconv.Itoa(n); !keys.has(candidate) {
+				name = candidate
+				break
+			}
+		}
+	}
+	keys.add(name)
+
+	j.elem()
+	j.writeString(name)
+	if j.pretty {
+		j.write(": ")
+	} else {
+		j.write(":")
+	}
+	j.afterKey = true
+}
+
+// raw writes a preformatted value
+func (j *jsonWriter) raw(value string) {
+	j.elem()
+	j.write(value)
+}
+
+// stringValue writes a string value
+func (j *jsonWriter) stringValue(value string) {
+	j.elem()
+	j.writeString(value)
+}
+
+// writeString writes a JSON string literal unless an earlier write failed
+func (j *jsonWriter) writeString(value string) {
+	if j.err == nil {
+		j.scratch = appendJSONString(j.scratch[:0], value)
+		_, j.err = j.w.Write(j.scratch)
+	}
+}
+
+// intValue writes an integer value
+func (j *jsonWriter) intValue(value int64) {
+	j.raw(strconv.FormatInt(value, 10))
+}
+
+// floatValue writes a floating point value. JSON has no representation
+// for NaN and infinities, so they are written as null.
+func (j *jsonWriter) floatValue(value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		j.raw("null")
+		return
+	}
+	j.raw(strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Flush writes buffered output and returns the first error encountered
+func (j *jsonWriter) Flush() error {
+	if j.err != nil {
+		return j.err
+	}
+	if j.pretty {
+		j.write("\n")
+	}
+	if j.err == nil {
+		j.err = j.w.Flush()
+	}
+	return j.err
+}
+
+// appendJSONString appends value to dst as a JSON string literal. Like
+// encoding/json it escapes control characters, U+2028 and U+2029 and
+// replaces invalid UTF-8 by U+FFFD, but it leaves HTML characters alone.
+func appendJSONString(dst []byte, value string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(value); {
+		if b := value[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, value[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, value[start:i]...)
+			dst = append(dst, `\ufffd`...)
+		case r == '\u2028' || r == '\u2029':
+			dst = append(dst, value[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xF])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	dst = append(dst, value[start:]...)
+	return append(dst, '"')
+}
+
+// jsonExporter writes WZ or NX trees as JSON. Nodes with children become
+// objects keyed by child name in file order, where siblings with the same
+// name get a numeric suffix ("0", "0_2"), and leaf values map to JSON
+// values:
+//
+//	int, double     number
+//	string          string
+//	vector/point    {"x": 0, "y": 0}
+//	UOL             {"uol": "../target"}
+//	Convex2D        [{"x": 0, "y": 0}, ...]
+//	empty           null
+//
+// Canvases and sounds become objects whose "_canvas" or "_sound" key
+// describes the media, followed by their child properties.
+type jsonExporter struct {
+	opts exportOptions
+	w    *jsonWriter
+}
+
+// writeWZDirectory writes a directory as an object of its subdirectories
+// followed by its images
+func (e *jsonExporter) writeWZDirectory(dir *wz.WZDirectory, path string) error {
+	e.w.beginObject()
+	for _, sub := range dir.Directories {
+		e.w.key(sub.Name)
+		if err := e.writeWZDirectory(sub, joinPath(path, sub.Name)); err != nil {
+			return err
+		}
+	}
+	for _, img := range dir.Images {
+		e.w.key(img.Name)
+		if err := e.writeWZImage(img, joinPath(path, img.Name)); err != nil {
+			return err
+		}
+	}
+	e.w.endObject()
+	return e.w.err
+}
+
+// writeWZImage parses an image, writes it and releases it again
+func (e *jsonExporter) writeWZImage(img *wz.WZImage, path string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parsing image %s: %v", path, r)
+		}
+	}()
+	img.ParseWithCopy()
+	defer img.Unload()
+
+	e.writeWZProperty(img.Properties, path)
+	return e.w.err
+}
+
+// writeWZProperty writes a property list as an object
+func (e *jsonExporter) writeWZProperty(prop *wz.WZProperty, path string) {
+	e.w.beginObject()
+	e.writeWZPropertyEntries(prop, path)
+	e.w.endObject()
+}
+
+// writeWZPropertyEntries writes the keys and values of a property list
+// into the current object
+func (e *jsonExporter) writeWZPropertyEntries(prop *wz.WZProperty, path string) {
+	if prop == nil {
+		return
+	}
+	for _, variant := range prop.Entries {
+		e.w.key(variant.Name)
+		e.writeWZVariant(variant, joinPath(path, variant.Name))
+	}
+}
+
+// writeWZVariant writes a single property value
+func (e *jsonExporter) writeWZVariant(variant *wz.WZVariant, path string) {
+	switch value := variant.Value.(type) {
+	case nil:
+		e.w.raw("null")
+	case int16:
+		e.w.intValue(int64(value))
+	case int32:
+		e.w.intValue(int64(value))
+	case int64:
+		e.w.intValue(value)
+	case float32:
+		e.w.floatValue(float64(value))
+	case float64:
+		e.w.floatValue(value)
+	case string:
+		e.w.stringValue(value)
+	default:
+		e.writeWZObject(value, path)
+	}
+}
+
+// writeWZObject writes a sub object
+func (e *jsonExporter) writeWZObject(obj interface{}, path string) {
+	switch v := obj.(type) {
+	case *wz.WZProperty:
+		e.writeWZProperty(v, path)
+
+	case *wz.WZCanvas:
+		e.w.beginObject()
+		e.w.key("_canvas")
+		e.writeBitmap(int(v.Width), int(v.Height), path, func() ([]byte, error) {
+			return encodeCanvasPNG(v)
+		})
+		e.writeWZPropertyEntries(v.Properties, path)
+		e.w.endObject()
+
+	case *wz.WZVector:
+		e.writePoint(v.X, v.Y)
+
+	case *wz.WZSoundDX8:
+		e.w.beginObject()
+		e.w.key("_sound")
+		e.writeAudio(v.SoundData, int64(v.Playtime), path)
+		e.w.endObject()
+
+	case *wz.WZUOL:
+		e.w.beginObject()
+		e.w.key("uol")
+		e.w.stringValue(v.Reference)
+		e.w.endObject()
+
+	case []interface{}:
+		e.w.beginArray()
+		for i, item := range v {
+			e.writeWZObject(item, joinPath(path, strconv.Itoa(i)))
+		}
+		e.w.endArray()
+
+	default:
+		e.w.raw("null")
+	}
+}
+
+// writeNXNode writes an NX node and its children
+func (e *jsonExporter) writeNXNode(node nx.Node, path string) error {
+	children := node.Children()
+	if len(children) == 0 {
+		e.writeNXValue(node, path)
+		return e.w.err
+	}
+
+	e.w.beginObject()
+	switch node.Type() {
+	case nx.TypeNone:
+	case nx.TypeBitmap:
+		e.w.key("_canvas")
+		e.writeNXValue(node, path)
+	case nx.TypeAudio:
+		e.w.key("_sound")
+		e.writeNXValue(node, path)
+	default:
+		e.w.key("_value")
+		e.writeNXValue(node, path)
+	}
+	for _, child := range children {
+		e.w.key(child.Name())
+		if err := e.writeNXNode(child, joinPath(path, child.Name())); err != nil {
+			return err
+		}
+	}
+	e.w.endObject()
+	return e.w.err
+}
+
+// writeNXValue writes the value of an NX node, ignoring its children
+func (e *jsonExporter) writeNXValue(node nx.Node, path string) {
+	switch node.Type() {
+	case nx.TypeInt64:
+		value, _ := node.Int()
+		e.w.intValue(value)
+	case nx.TypeDouble:
+		value, _ := node.Double()
+		e.w.floatValue(value)
+	case nx.TypeString:
+		value, _ := node.Str()
+		e.w.stringValue(value)
+	case nx.TypePoint:
+		x, y, _ := node.Point()
+		e.writePoint(x, y)
+	case nx.TypeBitmap:
+		bitmap, _ := node.Bitmap()
+		e.writeBitmap(int(bitmap.Width), int(bitmap.Height), path, func() ([]byte, error) {
+			img, err := node.Image()
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		})
+	case nx.TypeAudio:
+		data, err := node.AudioData()
+		if err != nil {
+			e.w.err = fmt.Errorf("%s: %w", path, err)
+			return
+		}
+		e.writeAudio(data, -1, path)
+	default:
+		e.w.raw("null")
+	}
+}
+
+// writePoint writes a vector as an object
+func (e *jsonExporter) writePoint(x, y int32) {
+	e.w.beginObject()
+	e.w.key("x")
+	e.w.intValue(int64(x))
+	e.w.key("y")
+	e.w.intValue(int64(y))
+	e.w.endObject()
+}
+
+// writeBitmap writes a bitmap description. encode is only called when the
+// pixels are exported.
+func (e *jsonExporter) writeBitmap(width, height int, path string, encode func() ([]byte, error)) {
+	e.w.beginObject()
+	e.w.key("width")
+	e.w.intValue(int64(width))
+	e.w.key("height")
+	e.w.intValue(int64(height))
+
+	if e.opts.Bitmaps != mediaOmit && width > 0 && height > 0 {
+		data, err := encode()
+		if err != nil {
+			e.w.key("error")
+			e.w.stringValue(err.Error())
+			e.opts.failed(fmt.Errorf("decoding bitmap %s: %w", path, err))
+		} else {
+			e.writeMedia(e.opts.Bitmaps, "png", data, path+".png")
+		}
+	}
+	e.w.endObject()
+}
+
+// encodeCanvasPNG decodes a canvas and encodes it as PNG
+func encodeCanvasPNG(canvas *wz.WZCanvas) ([]byte, error) {
+	img, err := converter.DecodeCanvas(canvas)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAudio writes an audio description. A negative playtime is unknown.
+func (e *jsonExporter) writeAudio(data []byte, playtime int64, path string) {
+	e.w.beginObject()
+	e.w.key("length")
+	e.w.intValue(int64(len(data)))
+	if playtime >= 0 {
+		e.w.key("playtime")
+		e.w.intValue(playtime)
+	}
+	if e.opts.Audio != mediaOmit && len(data) > 0 {
+		e.writeMedia(e.opts.Audio, "data", data, path+audioExtension(data))
+	}
+	e.w.endObject()
+}
+
+// writeMedia embeds data as base64 under key, or writes it to a file below
+// the media directory and records the relative path under "file". Names
+// that are unsafe as file names are escaped in both.
+func (e *jsonExporter) writeMedia(mode mediaMode, key string, data []byte, relPath string) {
+	if mode == mediaBase64 {
+		e.w.key(key)
+		e.w.stringValue(base64.StdEncoding.EncodeToString(data))
+		return
+	}
+
+	relPath = safePath(relPath)
+	target, err := outputPath(e.opts.MediaDir, relPath, "")
+	if err != nil {
+		e.w.err = err
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		e.w.err = err
+		return
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		e.w.err = err
+		return
+	}
+	e.w.key("file")
+	e.w.stringValue(relPath)
+}
+
+// audioExtension guesses a file extension from the leading bytes of an
+// audio payload
+func audioExtension(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("ID3")), len(data) > 1 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return ".mp3"
+	case bytes.HasPrefix(data, []byte("RIFF")):
+		return ".wav"
+	default:
+		return ".bin"
+	}
+}
+
+// exportJSON streams the whole input as a single JSON document
+func exportJSON(input string, w io.Writer, opts exportOptions) error {
+	jw := newJSONWriter(w, opts.Pretty)
+	e := &jsonExporter{opts: opts, w: jw}
+
+	file, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if file.NX != nil {
+		if err := e.writeNXNode(file.NX.Root(), ""); err != nil {
+			return err
+		}
+		return jw.Flush()
+	}
+	wzFile := file.WZ
+	if wzFile.Root == nil {
+		jw.raw("null")
+	} else if err := e.writeWZDirectory(wzFile.Root, ""); err != nil {
+		return err
+	}
+	return jw.Flush()
+}
+
+// exportJSONPerImage writes one JSON document per image below outDir, for
+// example Mob/100100.img.json. For NX input every node whose name ends in
+// ".img" is treated as an image.
+func exportJSONPerImage(input string, outDir string, opts exportOptions) error {
+	writeFile := func(path string, write func(e *jsonExporter) error) error {
+		target, err := outputPath(outDir, path, ".json")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		jw := newJSONWriter(f, opts.Pretty)
+		if err := write(&jsonExporter{opts: opts, w: jw}); err != nil {
+			return err
+		}
+		if err := jw.Flush(); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	file, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if file.NX != nil {
+		var walk func(node nx.Node, path string) error
+		walk = func(node nx.Node, path string) error {
+			if strings.HasSuffix(node.Name(), ".img") {
+				return writeFile(path, func(e *jsonExporter) error { return e.writeNXNode(node, path) })
+			}
+			for _, child := range node.Children() {
+				if err := walk(child, joinPath(path, child.Name())); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return walk(file.NX.Root(), "")
+	}
+	wzFile := file.WZ
+
+	var walk func(dir *wz.WZDirectory, path string) error
+	walk = func(dir *wz.WZDirectory, path string) error {
+		for _, sub := range dir.Directories {
+			if err := walk(sub, joinPath(path, sub.Name)); err != nil {
+				return err
+			}
+		}
+		for _, img := range dir.Images {
+			img, imgPath := img, joinPath(path, img.Name)
+			if err := writeFile(imgPath, func(e *jsonExporter) error { return e.writeWZImage(img, imgPath) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if wzFile.Root == nil {
+		return nil
+	}
+	return walk(wzFile.Root, "")
+}
+
+// runExport implements the export command and returns the process exit code
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "json", "Output format: json, or xml (classic imgdir dump, one .img.xml per image below -o)")
+	output := flags.String("o", "-", "Output file, or directory with --split (- for stdout)")
+	split := flags.Bool("split", false, "Write one file per image (e.g. Mob/100100.img.json) below the output directory")
+	bitmaps := flags.String("bitmaps", "omit", "Bitmap handling: omit, base64 (embedded PNG) or file (PNG files)")
+	audio := flags.String("audio", "omit", "Audio handling: omit, base64 or file")
+	mediaDir := flags.String("media-dir", "", "Directory for bitmap and audio files (default: next to the output)")
+	pretty := flags.Bool("pretty", false, "Indent the output")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go-wztonx-converter export [options] <file.wz|file.nx>")
+		fmt.Fprintln(flags.Output(), "Exports a WZ or NX tree, preserving node order.")
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	input := flags.Arg(0)
+
+	// Bitmaps that fail to decode are reported and left out, like the
+	// failed images of extract
+	failed := 0
+	opts := exportOptions{Pretty: *pretty, MediaDir: *mediaDir, Failed: func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		failed++
+	}}
+	var err error
+	if opts.Bitmaps, err = parseMediaMode(*bitmaps); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if opts.Audio, err = parseMediaMode(*audio); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if opts.MediaDir == "" {
+		switch {
+		case *split:
+			opts.MediaDir = *output
+		case *output == "-":
+			opts.MediaDir = "."
+		default:
+			opts.MediaDir = filepath.Dir(*output)
+		}
+	}
+
+	switch *format {
+	case "json":
+		if *split {
+			if *output == "-" {
+				fmt.Fprintln(os.Stderr, "Error: --split needs an output directory (-o)")
+				return 2
+			}
+			err = exportJSONPerImage(input, *output, opts)
+		} else if *output == "-" {
+			err = exportJSON(input, os.Stdout, opts)
+		} else {
+			err = exportToFile(*output, func(w io.Writer) error { return exportJSON(input, w, opts) })
+		}
+	case "xml":
+		if *output == "-" {
+			fmt.Fprintln(os.Stderr, "Error: the xml format needs an output directory (-o)")
+			return 2
+		}
+		err = exportXML(input, *output, opts)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q\n", *format)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// exportToFile creates filename and passes it to write
+func exportToFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// xmlHeader is the declaration that starts every classic XML dump
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`
+
+// xmlExporter writes WZ images in the classic imgdir XML schema used by
+// HaRepacker dumps and server emulators' wz/ folders:
+//
+//	<imgdir name="100100.img">
+//	  <imgdir name="info">
+//	    <int name="maxHP" value="8"/>
+//	  </imgdir>
+//	</imgdir>
+//
+// Only bitmaps and audio in base64 mode are embedded, as the basedata (and
+// basehead) attributes of canvas and sound elements.
+type xmlExporter struct {
+	opts  exportOptions
+	w     *bufio.Writer
+	depth int
+	err   error
+}
+
+// write writes raw output unless an earlier write failed
+func (e *xmlExporter) write(s string) {
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+// xmlEscape escapes an attribute value
+func xmlEscape(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
+
+// element writes an element with the given attributes. When open is set
+// the element is left open for children and must be closed with closeElement.
+func (e *xmlExporter) element(tag string, open bool, attrs ...string) {
+	e.write(strings.Repeat("  ", e.depth))
+	e.write("<" + tag)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		e.write(" " + attrs[i] + `="` + xmlEscape(attrs[i+1]) + `"`)
+	}
+	if open {
+		e.write(">\n")
+		e.depth++
+	} else {
+		e.write("/>\n")
+	}
+}
+
+// closeElement closes an element opened by element
+func (e *xmlExporter) closeElement(tag string) {
+	e.depth--
+	e.write(strings.Repeat("  ", e.depth) + "</" + tag + ">\n")
+}
+
+// writeImage writes a parsed image as a complete XML document
+func (e *xmlExporter) writeImage(img *wz.WZImage) {
+	e.write(xmlHeader + "\n")
+	e.writeContainer("imgdir", img.Properties, img.Name)
+}
+
+// writeContainer writes a property list as an element that holds its
+// entries, collapsing empty lists to a self-closing element
+func (e *xmlExporter) writeContainer(tag string, prop *wz.WZProperty, name string, attrs ...string) {
+	attrs = append([]string{"name", name}, attrs...)
+	if prop == nil || len(prop.Entries) == 0 {
+		e.element(tag, false, attrs...)
+		return
+	}
+	e.element(tag, true, attrs...)
+	for _, child := range prop.Entries {
+		e.writeVariant(child, child.Name)
+	}
+	e.closeElement(tag)
+}
+
+// writeVariant writes a single property
+func (e *xmlExporter) writeVariant(variant *wz.WZVariant, name string) {
+	switch value := variant.Value.(type) {
+	case nil:
+		e.element("null", false, "name", name)
+	case int16:
+		e.element("short", false, "name", name, "value", strconv.FormatInt(int64(value), 10))
+	case int32:
+		e.element("int", false, "name", name, "value", strconv.FormatInt(int64(value), 10))
+	case int64:
+		e.element("long", false, "name", name, "value", strconv.FormatInt(value, 10))
+	case float32:
+		e.element("float", false, "name", name, "value", strconv.FormatFloat(float64(value), 'f', -1, 32))
+	case float64:
+		e.element("double", false, "name", name, "value", strconv.FormatFloat(value, 'f', -1, 64))
+	case string:
+		e.element("string", false, "name", name, "value", value)
+	default:
+		e.writeObject(value, name)
+	}
+}
+
+// writeObject writes a sub object
+func (e *xmlExporter) writeObject(obj interface{}, name string) {
+	switch v := obj.(type) {
+	case *wz.WZProperty:
+		e.writeContainer("imgdir", v, name)
+
+	case *wz.WZCanvas:
+		attrs := []string{"width", strconv.Itoa(int(v.Width)), "height", strconv.Itoa(int(v.Height))}
+		if e.opts.Bitmaps == mediaBase64 && v.Width > 0 && v.Height > 0 {
+			if data, err := encodeCanvasPNG(v); err != nil {
+				// Like the JSON export, leave the pixels out and go on
+				e.opts.failed(fmt.Errorf("decoding bitmap %s: %w", v.GetPath(), err))
+			} else {
+				attrs = append(attrs, "basedata", base64.StdEncoding.EncodeToString(data))
+			}
+		}
+		e.writeContainer("canvas", v.Properties, name, attrs...)
+
+	case *wz.WZVector:
+		e.element("vector", false, "name", name, "x", strconv.Itoa(int(v.X)), "y", strconv.Itoa(int(v.Y)))
+
+	case *wz.WZSoundDX8:
+		attrs := []string{"name", name, "length", strconv.Itoa(int(v.Playtime))}
+		if e.opts.Audio == mediaBase64 {
+			attrs = append(attrs,
+				"basehead", base64.StdEncoding.EncodeToString(v.HeaderData),
+				"basedata", base64.StdEncoding.EncodeToString(v.SoundData))
+		}
+		e.element("sound", false, attrs...)
+
+	case *wz.WZUOL:
+		e.element("uol", false, "name", name, "value", v.Reference)
+
+	case []interface{}:
+		if len(v) == 0 {
+			e.element("extended", false, "name", name)
+			return
+		}
+		e.element("extended", true, "name", name)
+		for i, item := range v {
+			e.writeObject(item, strconv.Itoa(i))
+		}
+		e.closeElement("extended")
+
+	default:
+		e.element("null", false, "name", name)
+	}
+}
+
+// exportXMLImage writes one image to w
+func exportXMLImage(img *wz.WZImage, w io.Writer, opts exportOptions) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parsing image %s: %v", img.GetPath(), r)
+		}
+	}()
+	img.ParseWithCopy()
+	defer img.Unload()
+
+	e := &xmlExporter{opts: opts, w: bufio.NewWriterSize(w, converter.BufferSizeMB*1024*1024)}
+	e.writeImage(img)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// exportXML writes one .img.xml file per image of a WZ file below outDir,
+// mirroring the directory structure, e.g. Mob.wz/100100.img.xml
+func exportXML(input string, outDir string, opts exportOptions) error {
+	if opts.Bitmaps == mediaFile || opts.Audio == mediaFile {
+		return fmt.Errorf("the XML format only supports omit and base64 media")
+	}
+	if isNX(input) {
+		return fmt.Errorf("the XML format is built on WZ types and needs a WZ input")
+	}
+
+	wzFile, err := converter.OpenWZFile(input)
+	if err != nil {
+		return err
+	}
+	defer wzFile.Close()
+	if wzFile.Root == nil {
+		return nil
+	}
+	return exportXMLDirectory(wzFile.Root, outDir, wzFile.Root.Name, opts)
+}
+
+// exportXMLDirectory writes the images of a directory and its
+// subdirectories below outDir, at the node path dirPath. Names that are
+// unsafe as file names are escaped.
+func exportXMLDirectory(dir *wz.WZDirectory, outDir, dirPath string, opts exportOptions) error {
+	target, err := outputPath(outDir, dirPath, "")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for _, sub := range dir.Directories {
+		if err := exportXMLDirectory(sub, outDir, joinPath(dirPath, sub.Name), opts); err != nil {
+			return err
+		}
+	}
+	for _, img := range dir.Images {
+		img := img
+		target, err := outputPath(outDir, joinPath(dirPath, img.Name), ".xml")
+		if err != nil {
+			return err
+		}
+		err = exportToFile(target, func(w io.Writer) error {
+			return exportXMLImage(img, w, opts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// pathFilter selects node paths by patterns such as
+// "Mob/100100.img/stand/*". Paths start with the file name without
+// extension, so that path covers a stand animation of Mob.wz or Mob.nx. A
+// path is selected when a pattern matches it or one of its ancestors, so
+// "Mob/*.img" selects everything inside the matching images. An empty
+// filter selects every path.
+type pathFilter struct {
+	patterns patternList
+	filter   *converter.PathFilter
+}
+
+// String implements flag.Value
+func (f *pathFilter) String() string {
+	return f.patterns.String()
+}
+
+// Set implements flag.Value, adding a pattern
+func (f *pathFilter) Set(pattern string) error {
+	if err := f.patterns.Set(pattern); err != nil {
+		return err
+	}
+	filter, err := converter.NewPathFilter(f.patterns, nil)
+	if err != nil {
+		return err
+	}
+	f.filter = filter
+	return nil
+}
+
+// Match reports whether p is selected
+func (f pathFilter) Match(p string) bool {
+	return f.filter.Match(p)
+}
+
+// Enter reports whether p or one of its descendants may be selected, which
+// lets walks skip whole directories and images
+func (f pathFilter) Enter(p string) bool {
+	return f.filter.Enter(p)
+}
+
+// extractOptions controls what is extracted and where it is written
+type extractOptions struct {
+	// OutDir receives the files in a tree that mirrors the node paths
+	OutDir string
+	// Filter selects the node paths to extract
+	Filter pathFilter
+	// Sidecar writes a JSON file with the dimensions and child properties
+	// (origin, delay, z, ...) next to each image
+	Sidecar bool
+	// Workers is the number of images decoded at the same time
+	Workers int
+}
+
+// extractor runs extraction jobs on a bounded pool of workers. Jobs are
+// handed over unbuffered, so at most Workers jobs hold decoded data at once.
+type extractor struct {
+	opts extractOptions
+	// sounds extracts Sound_DX8 nodes instead of canvases
+	sounds bool
+	jobs   chan func() (int, error)
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	written  int
+	failures []error
+	manifest []soundEntry
+	// files counts the uses of each path written below OutDir, so that
+	// siblings with the same name get files of their own
+	files map[string]int
+}
+
+// newExtractor starts the worker pool
+func newExtractor(opts extractOptions) *extractor {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	x := &extractor{opts: opts, jobs: make(chan func() (int, error)), files: make(map[string]int)}
+	for i := 0; i < opts.Workers; i++ {
+		x.wg.Add(1)
+		go func() {
+			defer x.wg.Done()
+			for job := range x.jobs {
+				written, err := job()
+				x.mu.Lock()
+				x.written += written
+				if err != nil {
+					x.failures = append(x.failures, err)
+				}
+				x.mu.Unlock()
+			}
+		}()
+	}
+	return x
+}
+
+// submit queues a job that returns the number of files written
+func (x *extractor) submit(job func() (int, error)) {
+	x.jobs <- job
+}
+
+// wait stops the pool once all queued jobs are done
+func (x *extractor) wait() {
+	close(x.jobs)
+	x.wg.Wait()
+}
+
+// filePath returns the path, relative to OutDir and without extension, of
+// the files written for a node. Names that are unsafe as file names are
+// escaped by safePath. Siblings with the same name share a node path; all
+// but the first get a numeric suffix, as in "stand/0_2".
+func (x *extractor) filePath(nodePath string) string {
+	nodePath = safePath(nodePath)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	n := x.files[nodePath]
+	x.files[nodePath] = n + 1
+	if n == 0 {
+		return nodePath
+	}
+	for {
+		n++
+		candidate := fmt.Sprintf("%s_%d", nodePath, n)
+		if _, taken := x.files[candidate]; !taken {
+			x.files[candidate] = 1
+			return candidate
+		}
+	}
+}
+
+// writeNodeFile creates the file for a file path below OutDir and passes
+// it to write
+func (x *extractor) writeNodeFile(filePath, ext string, write func(f *os.File) error) error {
+	target, err := outputPath(x.opts.OutDir, filePath, ext)
+	if err != nil {
+		return err
+	}
+	return x.writeFile(target, write)
+}
+
+// writePNG encodes img to the PNG file for a file path
+func (x *extractor) writePNG(filePath string, img image.Image) error {
+	return x.writeNodeFile(filePath, ".png", func(f *os.File) error {
+		return png.Encode(f, img)
+	})
+}
+
+// writeSidecar writes the JSON description of a bitmap node. entries writes
+// the child properties into the open object.
+func (x *extractor) writeSidecar(filePath string, width, height int, entries func(e *jsonExporter)) error {
+	return x.writeNodeFile(filePath, ".json", func(f *os.File) error {
+		e := &jsonExporter{opts: exportOptions{Bitmaps: mediaOmit, Audio: mediaOmit}, w: newJSONWriter(f, true)}
+		e.w.beginObject()
+		e.w.key("width")
+		e.w.intValue(int64(width))
+		e.w.key("height")
+		e.w.intValue(int64(height))
+		entries(e)
+		e.w.endObject()
+		e.w.write("\n")
+		return e.w.Flush()
+	})
+}
+
+// writeFile creates filename and its directories and passes it to write
+func (x *extractor) writeFile(filename string, write func(f *os.File) error) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// extractWZDirectory queues one job per selected image of a directory tree
+func (x *extractor) extractWZDirectory(dir *wz.WZDirectory, dirPath string) {
+	for _, sub := range dir.Directories {
+		if childPath := joinPath(dirPath, sub.Name); x.opts.Filter.Enter(childPath) {
+			x.extractWZDirectory(sub, childPath)
+		}
+	}
+	for _, img := range dir.Images {
+		img, imgPath := img, joinPath(dirPath, img.Name)
+		if x.opts.Filter.Enter(imgPath) {
+			x.submit(func() (int, error) { return x.extractWZImage(img, imgPath) })
+		}
+	}
+}
+
+// extractWZImage parses an image, writes its selected canvases and
+// releases it again
+func (x *extractor) extractWZImage(img *wz.WZImage, imgPath string) (written int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parsing image %s: %v", imgPath, r)
+		}
+	}()
+	img.ParseWithCopy()
+	defer img.Unload()
+
+	var walk func(obj interface{}, objPath string) error
+	walkProperty := func(prop *wz.WZProperty, propPath string) error {
+		if prop == nil {
+			return nil
+		}
+		for _, variant := range prop.Entries {
+			childPath := joinPath(propPath, variant.Name)
+			if !x.opts.Filter.Enter(childPath) {
+				continue
+			}
+			if variant.Type == 9 {
+				if err := walk(variant.Value, childPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	walk = func(obj interface{}, objPath string) error {
+		switch v := obj.(type) {
+		case *wz.WZProperty:
+			return walkProperty(v, objPath)
+		case *wz.WZSoundDX8:
+			if x.sounds && x.opts.Filter.Match(objPath) {
+				if err := x.extractWZSound(v, objPath); err != nil {
+					return err
+				}
+				written++
+			}
+		case *wz.WZCanvas:
+			if !x.sounds && x.opts.Filter.Match(objPath) && v.Width > 0 && v.Height > 0 {
+				if err := x.extractWZCanvas(v, objPath); err != nil {
+					return err
+				}
+				written++
+			}
+			return walkProperty(v.Properties, objPath)
+		}
+		return nil
+	}
+	err = walkProperty(img.Properties, imgPath)
+	return written, err
+}
+
+// extractWZCanvas writes a canvas and its sidecar
+func (x *extractor) extractWZCanvas(canvas *wz.WZCanvas, canvasPath string) error {
+	img, err := converter.DecodeCanvas(canvas)
+	if err != nil {
+		return fmt.Errorf("decoding canvas %s: %w", canvasPath, err)
+	}
+	filePath := x.filePath(canvasPath)
+	if err := x.writePNG(filePath, img); err != nil {
+		return err
+	}
+	if !x.opts.Sidecar {
+		return nil
+	}
+	return x.writeSidecar(filePath, int(canvas.Width), int(canvas.Height), func(e *jsonExporter) {
+		e.writeWZPropertyEntries(canvas.Properties, canvasPath)
+	})
+}
+
+// extractNXNode queues one job per selected bitmap below node
+func (x *extractor) extractNXNode(node nx.Node, nodePath string) {
+	if node.Type() == nx.TypeBitmap && x.opts.Filter.Match(nodePath) {
+		x.submit(func() (int, error) {
+			if err := x.extractNXBitmap(node, nodePath); err != nil {
+				return 0, err
+			}
+			return 1, nil
+		})
+	}
+	for _, child := range node.Children() {
+		if childPath := joinPath(nodePath, child.Name()); x.opts.Filter.Enter(childPath) {
+			x.extractNXNode(child, childPath)
+		}
+	}
+}
+
+// extractNXBitmap writes a bitmap node and its sidecar
+func (x *extractor) extractNXBitmap(node nx.Node, nodePath string) error {
+	img, err := node.Image()
+	if err != nil {
+		return fmt.Errorf("decoding bitmap %s: %w", nodePath, err)
+	}
+	filePath := x.filePath(nodePath)
+	if err := x.writePNG(filePath, img); err != nil {
+		return err
+	}
+	if !x.opts.Sidecar {
+		return nil
+	}
+	bitmap, _ := node.Bitmap()
+	return x.writeSidecar(filePath, int(bitmap.Width), int(bitmap.Height), func(e *jsonExporter) {
+		for _, child := range node.Children() {
+			e.w.key(child.Name())
+			e.writeNXNode(child, joinPath(nodePath, child.Name()))
+		}
+	})
+}
+
+// soundEntry describes an extracted sound in the manifest
+type soundEntry struct {
+	Path          string `json:"path"`
+	File          string `json:"file"`
+	Format        string `json:"format"`
+	Playtime      int32  `json:"playtime"`
+	Size          int    `json:"size"`
+	FormatTag     uint16 `json:"format_tag,omitempty"`
+	Channels      uint16 `json:"channels,omitempty"`
+	SampleRate    uint32 `json:"sample_rate,omitempty"`
+	BitsPerSample uint16 `json:"bits_per_sample,omitempty"`
+}
+
+// extractWZSound writes a sound as a playable file: MP3 payloads as is and
+// PCM payloads wrapped in a WAV header built from the stored wave format
+func (x *extractor) extractWZSound(sound *wz.WZSoundDX8, soundPath string) error {
+	entry := soundEntry{Path: soundPath, Playtime: sound.Playtime, Size: len(sound.SoundData)}
+	data, ext := sound.SoundData, audioExtension(sound.SoundData)
+
+	if len(sound.WaveFormat) > 0 {
+		entry.FormatTag = sound.FormatTag
+		entry.Channels = sound.Channels
+		entry.SampleRate = sound.SampleRate
+		entry.BitsPerSample = sound.BitsPerSample
+	}
+	switch {
+	case sound.IsPCM() && ext != ".wav":
+		data, ext = buildWAV(sound), ".wav"
+		entry.Format = "pcm"
+	case sound.IsMP3(), ext == ".mp3":
+		ext = ".mp3"
+		entry.Format = "mp3"
+	case ext == ".wav":
+		entry.Format = "wav"
+	default:
+		entry.Format = "unknown"
+	}
+
+	filePath := x.filePath(soundPath)
+	entry.File = filePath + ext
+	if err := x.writeNodeFile(filePath, ext, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	x.manifest = append(x.manifest, entry)
+	x.mu.Unlock()
+	return nil
+}
+
+// extractSounds writes every selected sound of a WZ file and a
+// manifest.json describing them. It returns the number of sounds written
+// and the images that failed.
+func extractSounds(input string, opts extractOptions) (int, []error, error) {
+	if isNX(input) {
+		return 0, nil, fmt.Errorf("NX files do not keep sound headers; extract sounds from the WZ source")
+	}
+
+	wzFile, err := converter.OpenWZFile(input)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer wzFile.Close()
+
+	x := newExtractor(opts)
+	x.sounds = true
+	if wzFile.Root != nil {
+		x.extractWZDirectory(wzFile.Root, rootName(input))
+	}
+	x.wait()
+
+	if err := x.writeManifest(); err != nil {
+		return x.written, x.failures, err
+	}
+	return x.written, x.failures, nil
+}
+
+// writeManifest writes the extracted sounds, sorted by path, to
+// manifest.json in the output directory
+func (x *extractor) writeManifest() error {
+	sort.Slice(x.manifest, func(i, j int) bool {
+		if x.manifest[i].Path != x.manifest[j].Path {
+			return x.manifest[i].Path < x.manifest[j].Path
+		}
+		return x.manifest[i].File < x.manifest[j].File
+	})
+	if x.manifest == nil {
+		x.manifest = []soundEntry{}
+	}
+	return x.writeFile(filepath.Join(x.opts.OutDir, "manifest.json"), func(f *os.File) error {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(x.manifest)
+	})
+}
+
+// extractImages writes every selected canvas of a WZ file, or bitmap of an
+// NX file, as a PNG. It returns the number of images written and the
+// images that failed.
+func extractImages(input string, opts extractOptions) (int, []error, error) {
+	file, err := openInput(input)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	x := newExtractor(opts)
+	if file.NX != nil {
+		x.extractNXNode(file.NX.Root(), rootName(input))
+	} else if file.WZ.Root != nil {
+		x.extractWZDirectory(file.WZ.Root, rootName(input))
+	}
+	x.wait()
+	return x.written, x.failures, nil
+}
+
+// extractCommands maps the kinds of data that can be extracted to their
+// implementations
+var extractCommands = map[string]func(args []string) int{
+	"images": runExtractImages,
+	"sounds": runExtractSounds,
+}
+
+// runExtract implements the extract command and returns the process exit code
+func runExtract(args []string) int {
+	if len(args) > 0 {
+		if run, ok := extractCommands[args[0]]; ok {
+			return run(args[1:])
+		}
+	}
+	w, code := os.Stderr, 2
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		w, code = os.Stdout, 0
+	}
+	fmt.Fprintln(w, "Usage: go-wztonx-converter extract images [options] <file.wz|file.nx>")
+	fmt.Fprintln(w, "       go-wztonx-converter extract sounds [options] <file.wz>")
+	return code
+}
+
+// runExtractImages implements "extract images": 0 when every image was
+// written, 1 when some failed and 2 on errors
+func runExtractImages(args []string) int {
+	var opts extractOptions
+	flags := flag.NewFlagSet("extract images", flag.ExitOnError)
+	flags.StringVar(&opts.OutDir, "o", ".", "Output directory")
+	flags.Var(&opts.Filter, "path", "Only extract below node paths matching this glob or re: regular expression, e.g. 'Mob/*.img/stand' (repeatable)")
+	flags.BoolVar(&opts.Sidecar, "sidecar", false, "Write a JSON file with origin, delay, z and other child properties next to each PNG")
+	flags.IntVar(&opts.Workers, "workers", runtime.NumCPU(), "Number of images decoded in parallel")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go-wztonx-converter extract images [options] <file.wz|file.nx>")
+		fmt.Fprintln(flags.Output(), "Writes every canvas as a PNG in a tree that mirrors node paths (Mob/100100.img/stand/0.png for Mob.wz).")
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	written, failures, err := extractImages(flags.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", failure)
+	}
+	fmt.Printf("Extracted %d image(s) to %s\n", written, opts.OutDir)
+
+	if len(failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runExtractSounds implements "extract sounds": 0 when every sound was
+// written, 1 when some failed and 2 on errors
+func runExtractSounds(args []string) int {
+	var opts extractOptions
+	flags := flag.NewFlagSet("extract sounds", flag.ExitOnError)
+	flags.StringVar(&opts.OutDir, "o", ".", "Output directory")
+	flags.Var(&opts.Filter, "path", "Only extract below node paths matching this glob or re: regular expression, e.g. 'Sound/Bgm0*.img' (repeatable)")
+	flags.IntVar(&opts.Workers, "workers", runtime.NumCPU(), "Number of images processed in parallel")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go-wztonx-converter extract sounds [options] <file.wz>")
+		fmt.Fprintln(flags.Output(), "Writes every Sound_DX8 node as an MP3 or WAV file in a tree that mirrors node paths")
+		fmt.Fprintln(flags.Output(), "(Sound/Bgm00.img/GoPicnic.mp3 for Sound.wz), plus a manifest.json of durations and formats.")
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	written, failures, err := extractSounds(flags.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", failure)
+	}
+	fmt.Printf("Extracted %d sound(s) to %s\n", written, opts.OutDir)
+
+	if len(failures) > 0 {
+		return 1
+	}
+	return 0
+}
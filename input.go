@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// inputFile is a WZ or NX file opened by a command. Exactly one of WZ and
+// NX is set.
+type inputFile struct {
+	WZ *wz.WZFile
+	NX *nx.File
+}
+
+// isNX reports whether a file is read as NX, by its extension
+func isNX(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".nx")
+}
+
+// rootName returns the name that heads the node paths of a file: its base
+// name without extension, the name a merge mounts it under
+func rootName(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+}
+
+// openInput opens an NX file, or parses the directories of a WZ file
+func openInput(filename string) (*inputFile, error) {
+	if isNX(filename) {
+		file, err := nx.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		return &inputFile{NX: file}, nil
+	}
+	wzFile, err := converter.OpenWZFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &inputFile{WZ: wzFile}, nil
+}
+
+func (f *inputFile) Close() error {
+	if f.NX != nil {
+		return f.NX.Close()
+	}
+	return f.WZ.Close()
+}
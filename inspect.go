@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// inspectReport summarizes a WZ or NX file
+type inspectReport struct {
+	File   string `json:"file"`
+	Format string `json:"format"` // wz or nx
+
+	// WZ files
+	Description string `json:"description,omitempty"`
+	Directories int    `json:"directories,omitempty"`
+	Images      int    `json:"images,omitempty"`
+
+	// NX files
+	Header *nx.Header `json:"header,omitempty"`
+
+	// Nodes lists the nodes down to the requested depth, in file order
+	Nodes []inspectNode `json:"nodes"`
+}
+
+// inspectNode is a node listed by inspect. WZ nodes are directories and
+// images, whose children are not counted since that would parse them.
+type inspectNode struct {
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	Children int    `json:"children,omitempty"`
+}
+
+// inspectWZ summarizes a parsed WZ file, listing directories and images
+// down to depth levels
+func inspectWZ(filename string, file *wz.WZFile, depth int) inspectReport {
+	report := inspectReport{File: filename, Format: "wz", Description: file.FileDescription, Nodes: []inspectNode{}}
+	var walk func(dir *wz.WZDirectory, path string, level int)
+	walk = func(dir *wz.WZDirectory, path string, level int) {
+		report.Directories += len(dir.Directories)
+		report.Images += len(dir.Images)
+		for _, sub := range dir.Directories {
+			subPath := joinPath(path, sub.Name)
+			if level < depth {
+				report.Nodes = append(report.Nodes, inspectNode{
+					Path:     subPath,
+					Type:     "directory",
+					Children: len(sub.Directories) + len(sub.Images),
+				})
+			}
+			walk(sub, subPath, level+1)
+		}
+		if level < depth {
+			for _, img := range dir.Images {
+				report.Nodes = append(report.Nodes, inspectNode{Path: joinPath(path, img.Name), Type: "image"})
+			}
+		}
+	}
+	if file.Root != nil {
+		walk(file.Root, "", 0)
+	}
+	return report
+}
+
+// inspectNX summarizes an NX file from its header, listing nodes down to
+// depth levels
+func inspectNX(filename string, file *nx.File, depth int) inspectReport {
+	header := file.Header
+	report := inspectReport{File: filename, Format: "nx", Header: &header, Nodes: []inspectNode{}}
+	var walk func(node nx.Node, path string, level int)
+	walk = func(node nx.Node, path string, level int) {
+		if level >= depth {
+			return
+		}
+		for _, child := range node.Children() {
+			childPath := joinPath(path, child.Name())
+			report.Nodes = append(report.Nodes, inspectNode{
+				Path:     childPath,
+				Type:     child.Type().String(),
+				Children: len(child.Children()),
+			})
+			walk(child, childPath, level+1)
+		}
+	}
+	walk(file.Root(), "", 0)
+	return report
+}
+
+// writeInspectText prints a report for people
+func writeInspectText(w io.Writer, report inspectReport) {
+	if report.Header != nil {
+		h := report.Header
+		fmt.Fprintf(w, "%s: NX file, %d nodes, %d strings, %d bitmaps, %d audio\n",
+			report.File, h.NodeCount, h.StringCount, h.BitmapCount, h.AudioCount)
+	} else {
+		fmt.Fprintf(w, "%s: WZ file %q, %d directories, %d images\n",
+			report.File, report.Description, report.Directories, report.Images)
+	}
+	for _, node := range report.Nodes {
+		indent := strings.Repeat("  ", strings.Count(node.Path, "/")+1)
+		name := node.Path[strings.LastIndex(node.Path, "/")+1:]
+		switch node.Children {
+		case 0:
+			fmt.Fprintf(w, "%s%s (%s)\n", indent, name, node.Type)
+		case 1:
+			fmt.Fprintf(w, "%s%s (%s, 1 child)\n", indent, name, node.Type)
+		default:
+			fmt.Fprintf(w, "%s%s (%s, %d children)\n", indent, name, node.Type, node.Children)
+		}
+	}
+}
+
+// runInspect implements the inspect command and returns the process exit
+// code
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	depth := flags.Int("depth", 1, "Levels of nodes to list below the root (0 for none)")
+	asJSON := flags.Bool("json", false, "Print the summary as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go-wztonx-converter inspect [options] <file.wz|file.nx>")
+		fmt.Fprintln(flags.Output(), "Summarizes a WZ or NX file and lists its top nodes.")
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	input := flags.Arg(0)
+
+	file, err := openInput(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	defer file.Close()
+
+	var report inspectReport
+	if file.NX != nil {
+		report = inspectNX(input, file.NX, *depth)
+	} else {
+		report = inspectWZ(input, file.WZ, *depth)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+	writeInspectText(os.Stdout, report)
+	return 0
+}
@@ -0,0 +1,138 @@
+// Package wztest builds in-memory WZ trees for tests
+package wztest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// NewProperty creates an empty property list
+func NewProperty() *wz.WZProperty {
+	return wz.NewWZProperty(0)
+}
+
+// AddVariant appends a value to a property list
+func AddVariant(prop *wz.WZProperty, parent *wz.WZSimpleNode, name string, typ uint8, value interface{}) *wz.WZVariant {
+	variant := wz.NewWZVariant(name, parent)
+	variant.Type = typ
+	variant.Value = value
+	prop.Add(variant)
+	return variant
+}
+
+// BuildDirectory builds an in-memory WZ tree covering every value type:
+//
+//	Test.wz
+//	  Mob/
+//	    100100.img: info{maxHP, speed, name, rate}, stand/0 (canvas), link (UOL), foothold (Convex2D)
+//	  Sound.img: bgm (Sound_DX8)
+func BuildDirectory() *wz.WZDirectory {
+	root := wz.NewWZDirectory("Test.wz", nil)
+
+	mob := wz.NewWZDirectory("Mob", root.WZSimpleNode)
+	root.AddDirectory(mob)
+
+	img := wz.NewWZImage("100100.img", mob.WZSimpleNode)
+	img.Parsed = true
+	img.Properties = NewProperty()
+	mob.AddImage(img)
+
+	info := NewProperty()
+	infoVariant := AddVariant(img.Properties, img.WZSimpleNode, "info", 9, info)
+	AddVariant(info, infoVariant.WZSimpleNode, "maxHP", 3, int32(8))
+	AddVariant(info, infoVariant.WZSimpleNode, "speed", 2, int16(-30))
+	AddVariant(info, infoVariant.WZSimpleNode, "name", 8, "Snail")
+	AddVariant(info, infoVariant.WZSimpleNode, "rate", 4, float32(0.5))
+
+	stand := NewProperty()
+	standVariant := AddVariant(img.Properties, img.WZSimpleNode, "stand", 9, stand)
+	canvasVariant := AddVariant(stand, standVariant.WZSimpleNode, "0", 9, nil)
+	canvas := wz.NewWZCanvas("0", canvasVariant.WZSimpleNode)
+	canvas.Width, canvas.Height, canvas.Format1 = 2, 1, 2
+	canvas.Data = []byte{0x10, 0x20, 0x30, 0xFF, 0x40, 0x50, 0x60, 0x80}
+	canvas.Properties = NewProperty()
+	originVariant := AddVariant(canvas.Properties, canvasVariant.WZSimpleNode, "origin", 9, nil)
+	origin := wz.NewWZVector("origin", originVariant.WZSimpleNode)
+	origin.X, origin.Y = 13, 27
+	originVariant.Value = origin
+	AddVariant(canvas.Properties, canvasVariant.WZSimpleNode, "delay", 3, int32(120))
+	canvasVariant.Value = canvas
+
+	linkVariant := AddVariant(img.Properties, img.WZSimpleNode, "link", 9, nil)
+	link := wz.NewWZUOL("link", linkVariant.WZSimpleNode)
+	link.Reference = "stand/0"
+	linkVariant.Value = link
+
+	convexVariant := AddVariant(img.Properties, img.WZSimpleNode, "foothold", 9, nil)
+	point := wz.NewWZVector("0", convexVariant.WZSimpleNode)
+	point.X, point.Y = -5, 6
+	convexVariant.Value = []interface{}{point}
+
+	soundImg := wz.NewWZImage("Sound.img", root.WZSimpleNode)
+	soundImg.Parsed = true
+	soundImg.Properties = NewProperty()
+	root.AddImage(soundImg)
+
+	soundVariant := AddVariant(soundImg.Properties, soundImg.WZSimpleNode, "bgm", 9, nil)
+	sound := wz.NewWZSoundDX8("bgm", soundVariant.WZSimpleNode)
+	sound.Playtime = 1500
+	sound.SoundData = []byte{0xFF, 0xFB, 0x90, 0x00}
+	soundVariant.Value = sound
+
+	return root
+}
+
+// EncodeDirectories encodes an unencrypted version 1 WZ file whose root
+// holds one empty directory per name. Names must be short ASCII strings.
+func EncodeDirectories(names ...string) []byte {
+	const contentsStart = 17 // header, file size, contents start and an empty description
+
+	// The version hash of version 1, as computed by the wz package
+	var hash uint32
+	for _, c := range []byte(strconv.Itoa(1)) {
+		hash = hash<<5 + uint32(c+1)
+	}
+	version := uint16(0xFF) ^ uint16(hash>>24&0xFF) ^ uint16(hash>>16&0xFF) ^ uint16(hash>>8&0xFF) ^ uint16(hash&0xFF)
+
+	var buf bytes.Buffer
+	buf.WriteString("PKG1")
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+	binary.Write(&buf, binary.LittleEndian, int32(contentsStart))
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.LittleEndian, version)
+
+	// Each entry is 1 type byte, the name, 2 zero WZ ints and 4 offset
+	// bytes. The empty subdirectories follow the root directory.
+	entriesEnd := buf.Len() + 1
+	for _, name := range names {
+		entriesEnd += 1 + 1 + len(name) + 2 + 4
+	}
+
+	buf.WriteByte(byte(len(names)))
+	for i, name := range names {
+		buf.WriteByte(3)
+		buf.WriteByte(byte(-int8(len(name))))
+		mask := byte(0xAA)
+		for _, c := range []byte(name) {
+			buf.WriteByte(c ^ mask)
+			mask++
+		}
+		buf.Write([]byte{0, 0})
+
+		// Inverse of the offset decryption of the wz package
+		offset := uint32(buf.Len()-contentsStart) ^ 0xFFFFFFFF
+		offset *= hash
+		offset -= 0x581C3F6D
+		offset = bits.RotateLeft32(offset, int(offset&0x1F))
+		target := uint32(entriesEnd+i) - contentsStart*2
+		binary.Write(&buf, binary.LittleEndian, offset^target)
+	}
+	for range names {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
@@ -5,15 +5,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
 )
 
 // Version information (set by GoReleaser)
@@ -23,121 +29,714 @@ var (
 	date    = "unknown"
 )
 
+// command is a subcommand of the tool
+type command struct {
+	name    string
+	summary string
+	// run runs the command with the arguments after its name and returns
+	// the process exit code
+	run func(args []string) int
+}
+
+// commands lists the subcommands in the order of the usage text
+var commands = []command{
+	{"convert", "Convert WZ files into NX files", runConvert},
+	{"inspect", "Summarize a WZ or NX file and list its top nodes", runInspect},
+	{"extract", "Write the images or sounds of a WZ or NX file as files", runExtract},
+	{"verify", "Structurally validate NX files", runVerify},
+	{"diff", "Check that an NX file holds exactly the data of its WZ source", runDiff},
+	{"export", "Export a WZ or NX tree as JSON or XML", runExport},
+}
+
+// commandAliases maps former command names to their commands
+var commandAliases = map[string]string{
+	"compare": "diff",
+}
+
+// findCommand returns the command called name, or nil
+func findCommand(name string) *command {
+	if alias, ok := commandAliases[name]; ok {
+		name = alias
+	}
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage prints the commands of the tool
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: go-wztonx-converter <command> [options] <arguments>")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "go-wztonx-converter help <command>" for the options of a command.`)
+	fmt.Fprintln(w, "Arguments without a command are passed to convert, where -c, -s and -h")
+	fmt.Fprintln(w, "are kept as short forms of --client, --server and --lz4hc.")
+}
+
 func main() {
-	fmt.Println("NoLifeWzToNx - Go Edition")
-	fmt.Printf("Version: %s (commit: %s, built: %s)\n", version, commit, date)
-	fmt.Println("Converts WZ files into NX files")
-	fmt.Println()
-
-	client := flag.Bool("client", false, "Client mode (process audio and bitmaps)")
-	clientShort := flag.Bool("c", false, "Client mode (short)")
-	server := flag.Bool("server", false, "Server mode")
-	serverShort := flag.Bool("s", false, "Server mode (short)")
-	lz4hc := flag.Bool("lz4hc", false, "Use LZ4 high compression")
-	lz4hcShort := flag.Bool("h", false, "Use LZ4 high compression (short)")
-	debug := flag.Bool("debug", false, "Enable debug logging to file")
-	cpuProfile := flag.String("cpuprofile", "", "Write CPU profile to file")
-	memProfile := flag.String("memprofile", "", "Write memory profile to file")
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) == 0 {
+		// As before commands existed, a bare invocation prints usage and
+		// succeeds
+		usage(os.Stdout)
+		return
+	}
+	switch args[0] {
+	case "help", "-help", "--help":
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				os.Exit(cmd.run([]string{"-h"}))
+			}
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[1])
+			usage(os.Stderr)
+			os.Exit(2)
+		}
+		usage(os.Stdout)
+		return
+	}
+	if cmd := findCommand(args[0]); cmd != nil {
+		os.Exit(cmd.run(args[1:]))
+	}
+	os.Exit(convertCommand("go-wztonx-converter", args, true))
+}
+
+// runConvert implements the convert command
+func runConvert(args []string) int {
+	return convertCommand("go-wztonx-converter convert", args, false)
+}
+
+// convertCommand converts the files named by args and returns the process
+// exit code. legacy accepts the short flags of invocations without a
+// command, where -h is --lz4hc rather than help.
+func convertCommand(name string, args []string, legacy bool) int {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	client := flags.Bool("client", false, "Client mode (process audio and bitmaps)")
+	server := flags.Bool("server", false, "Server mode")
+	lz4hc := flags.Bool("lz4hc", false, "Use LZ4 high compression")
+	if legacy {
+		flags.BoolVar(client, "c", false, "Client mode (short)")
+		flags.BoolVar(server, "s", false, "Server mode (short)")
+		flags.BoolVar(lz4hc, "h", false, "Use LZ4 high compression (short)")
+	}
+	debug := flags.Bool("debug", false, "Log debug records, same as --log-level debug")
+	dimensions := flags.Bool("dimensions", false, "Server mode: keep canvas sizes as _width and _height child nodes")
+	noDedup := flags.Bool("no-dedup", false, "Store identical bitmaps and audio separately instead of sharing one entry")
+	dedupNodes := flags.Bool("dedup-nodes", false, "Let nodes with identical subtrees share one child range in the node table")
+	merge := flags.String("merge", "", "Merge all WZ inputs into this NX file, each under a node named after the file")
+	emit := flags.String("emit", "", "Write several outputs from one parse, e.g. client={name}.nx,server={name}.server.nx")
+	outDir := flags.String("out-dir", "", "Write NX files below this directory, mirroring the directories of the inputs, instead of next to each input")
+	nameTemplate := flags.String("name", "{name}.nx", "Name of the NX file of each input; {name} is the input name without extension, {mode} client or server")
+	overwrite := flags.Bool("overwrite", false, "Replace NX files that already exist (default: an existing NX file fails its input)")
+	skipExisting := flags.Bool("skip-existing", false, "Leave NX files that already exist alone and skip their inputs (default: an existing NX file fails its input)")
+	var include, exclude patternList
+	flags.Var(&include, "include", "Only convert below node paths matching this glob or re: regular expression, e.g. Map/Map/Map1 (repeatable)")
+	flags.Var(&exclude, "exclude", "Leave out node paths matching this glob or re: regular expression, e.g. Character/Afterimage (repeatable)")
+	sortNodes := flags.Bool("sort", false, "Sort sibling nodes by name like the original C++ tool instead of keeping WZ order")
+	uol := flags.String("uol", "drop", "How to convert UOL links: drop (empty node), string (link path) or resolve (copy of the target)")
+	errorPolicy := flags.String("errors", "continue", "On failed images or files: fail-fast (stop, exit 1), continue (convert the rest, exit 1) or best-effort (convert the rest, exit 0)")
+	workers := flags.Int("workers", 0, "Images parsed and bitmaps compressed at once (0 = one per CPU)")
+	metadata := flags.Bool("metadata", false, "Attach reserved child nodes (_format, _maglevel, _playtime, _samplerate, ...) to canvases and sounds")
+	cpuProfile := flags.String("cpuprofile", "", "Write CPU profile to file")
+	memProfile := flags.String("memprofile", "", "Write memory profile to file")
+	output := flags.String("o", "", "Write the NX file of a single WZ input to this path instead of next to it (- for stdout)")
+	progressMode := flags.String("progress", "auto", "Progress display: auto (a bar on terminals), bar, json (one event per line on stdout) or none")
+	logLevel := flags.String("log-level", "info", "Least severe log records shown: debug, info, warn or error")
+	logFormat := flags.String("log-format", "text", "Log record format: text or json")
+	quiet := flags.Bool("quiet", false, "Only log warnings and errors and show no progress unless --progress is given")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] <files/directories>\n", name)
+		fmt.Fprintf(flags.Output(), "       %s [options] -o <file.nx|-> <file.wz>\n", name)
+		fmt.Fprintln(flags.Output(), "Converts WZ files, and the WZ files found in directories, into NX files.")
+		fmt.Fprintln(flags.Output(), "Options:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// With the NX file or JSON progress on stdout, messages go to stderr
+	var progressOut io.Writer = os.Stdout
+	if *output == "-" {
+		console = os.Stderr
+		progressOut = os.Stderr
+	}
+	if *progressMode == "json" {
+		console = os.Stderr
+	}
+
+	level := *logLevel
+	switch {
+	case *debug:
+		level = "debug"
+	case *quiet:
+		level = "warn"
+		if !flagSet(flags, "progress") {
+			*progressMode = "none"
+		}
+	}
+	newConsoleLogger := func(w io.Writer) *slog.Logger {
+		logger, err := newLogger(w, level, *logFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		slog.SetDefault(logger)
+		return logger
+	}
+	logger := newConsoleLogger(console)
+
+	logger.Info("NoLifeWzToNx - Go Edition, converts WZ files into NX files",
+		"version", version, "commit", commit, "built", date)
 
 	// CPU profiling
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
-			log.Fatal("Could not create CPU profile: ", err)
+			fatal("could not create CPU profile", "error", err)
 		}
 		defer f.Close()
 		if err := pprof.StartCPUProfile(f); err != nil {
-			log.Fatal("Could not start CPU profile: ", err)
+			fatal("could not start CPU profile", "error", err)
 		}
 		defer pprof.StopCPUProfile()
-		fmt.Printf("CPU profiling enabled, writing to %s\n", *cpuProfile)
+		logger.Info("CPU profiling enabled", "file", *cpuProfile)
 	}
 
-	// Memory profiling (defer to end of main)
+	// Memory profiling (defer to the end of the command)
 	if *memProfile != "" {
 		defer func() {
 			f, err := os.Create(*memProfile)
 			if err != nil {
-				log.Fatal("Could not create memory profile: ", err)
+				fatal("could not create memory profile", "error", err)
 			}
 			defer f.Close()
 			runtime.GC() // get up-to-date statistics
 			if err := pprof.WriteHeapProfile(f); err != nil {
-				log.Fatal("Could not write memory profile: ", err)
+				fatal("could not write memory profile", "error", err)
 			}
-			fmt.Printf("Memory profile written to %s\n", *memProfile)
+			slog.Info("memory profile written", "file", *memProfile)
 		}()
 	}
 
-	isClient := *client || *clientShort
-	isServer := *server || *serverShort
-	useHC := *lz4hc || *lz4hcShort
-	enableDebug := *debug
+	opts := convertOptions{
+		Options: converter.Options{
+			Sort:             *sortNodes,
+			Workers:          *workers,
+			Metadata:         *metadata,
+			ServerDimensions: *dimensions,
+			NoDedup:          *noDedup,
+			DedupNodes:       *dedupNodes,
+		},
+	}
 
 	// If server is specified, client is false
-	if isServer {
-		isClient = false
+	if *client && !*server {
+		opts.Mode = converter.Client
+	}
+	if *lz4hc {
+		opts.Compression = converter.LZ4HC
+	}
+	uols, err := converter.ParseUOLPolicy(*uol)
+	if err != nil {
+		fatal("invalid --uol", "error", err)
+	}
+	opts.UOLs = uols
+	policy, err := converter.ParseErrorPolicy(*errorPolicy)
+	if err != nil {
+		fatal("invalid --errors", "error", err)
+	}
+	opts.Errors = policy
+	var filter *converter.PathFilter
+	if len(include) > 0 || len(exclude) > 0 {
+		if filter, err = converter.NewPathFilter(include, exclude); err != nil {
+			fatal("invalid --include or --exclude", "error", err)
+		}
+		opts.Filter = filter
 	}
 
-	paths := flag.Args()
+	if *output != "" && (*merge != "" || *emit != "") {
+		fatal("-o cannot be combined with --merge or --emit")
+	}
+	if (*output != "" || *merge != "") && (*outDir != "" || flagSet(flags, "name")) {
+		fatal("--out-dir and --name name the outputs of each input, not those of -o or --merge")
+	}
+	if *overwrite && *skipExisting {
+		fatal("--overwrite cannot be combined with --skip-existing")
+	}
+	opts.OutDir = *outDir
+	opts.NameTemplate = *nameTemplate
+	switch {
+	case *overwrite:
+		opts.Existing = existingOverwrite
+	case *skipExisting:
+		opts.Existing = existingSkip
+	}
+	if *emit != "" {
+		outputs, err := parseEmit(*emit)
+		if err != nil {
+			fatal("invalid --emit", "error", err)
+		}
+		opts.Emit = outputs
+	}
+
+	paths := flags.Args()
 	if len(paths) == 0 {
-		fmt.Println("Usage: go-wztonx-converter [options] <files/directories>")
-		fmt.Println("Options:")
-		flag.PrintDefaults()
-		return
+		if legacy {
+			// Invocations without a command and without inputs always
+			// printed usage and succeeded
+			flags.SetOutput(os.Stdout)
+			flags.Usage()
+			return 0
+		}
+		flags.Usage()
+		return 2
 	}
 
+	// SIGINT and SIGTERM cancel the conversion, which removes its partial
+	// output. A second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	startTime := time.Now()
 
-	for _, path := range paths {
-		if err := processPath(path, isClient, useHC, enableDebug); err != nil {
-			log.Printf("Error processing %s: %v\n", path, err)
+	// Find the inputs first, so that progress covers the whole run
+	var inputs []inputPath
+	switch {
+	case *output != "":
+		if len(paths) != 1 {
+			fatal("-o needs exactly one input file")
+		}
+		if info, err := os.Stat(paths[0]); err == nil && info.IsDir() {
+			fatal("-o needs a WZ file, not a directory")
+		}
+		inputs = []inputPath{{Path: paths[0], Rel: filepath.Base(paths[0])}}
+	case *merge != "":
+		inputs = findInputs(paths, filter, ".wz")
+		if len(inputs) == 0 {
+			fatal("no WZ files found to merge")
 		}
+	default:
+		inputs = findInputs(paths, filter, ".wz", ".img")
+	}
+	var totalBytes int64
+	for _, input := range inputs {
+		totalBytes += fileSize(input.Path)
+	}
+	files := len(inputs)
+	if *merge != "" {
+		files = 1
 	}
 
-	elapsed := time.Since(startTime)
-	fmt.Printf("Took %d seconds\n", int(elapsed.Seconds()))
+	// The bar shares the console, JSON events have a stream of their own
+	if *progressMode != "json" {
+		progressOut = console
+	}
+	reporter, err := newProgressReporter(*progressMode, progressOut, files, totalBytes)
+	if err != nil {
+		fatal("invalid --progress", "error", err)
+	}
+	if bar, ok := reporter.(*progressBar); ok {
+		// Log records are printed above the bar
+		logger = newConsoleLogger(bar.messages(console))
+	}
+	opts.Logger = logger
+	opts.Progress = reporter.report
+
+	var failures failureSummary
+	switch {
+	case *output != "":
+		reporter.startInput(inputs[0].Path, totalBytes)
+		result, err := convertTo(ctx, inputs[0].Path, *output, opts)
+		failures.add(ctx, inputs[0].Path, result, err)
+	case *merge != "":
+		filenames := make([]string, len(inputs))
+		for i, input := range inputs {
+			filenames[i] = input.Path
+		}
+		reporter.startInput(*merge, totalBytes)
+		result, err := mergeFiles(ctx, filenames, *merge, opts)
+		failures.add(ctx, *merge, result, err)
+	default:
+		for _, input := range inputs {
+			reporter.startInput(input.Path, fileSize(input.Path))
+			result, err := convertFile(ctx, input, opts)
+			failures.add(ctx, input.Path, result, err)
+			if ctx.Err() != nil || (policy == converter.FailFast && failures.failed()) {
+				break
+			}
+		}
+	}
+	reporter.finish()
+
+	if ctx.Err() != nil {
+		logger.Warn("interrupted, partial output removed")
+		return 130
+	}
+
+	failures.report(logger, policy)
+	logger.Info("done", "elapsed", time.Since(startTime).Round(time.Second))
+	if failures.failed() && policy != converter.BestEffort {
+		return 1
+	}
+	return 0
 }
 
-func processPath(path string, client bool, hc bool, debug bool) error {
-	info, err := os.Stat(path)
-	if err != nil {
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// flagSet reports whether the flag name was given on the command line
+func flagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// patternList collects the patterns of a repeatable flag: globs, or
+// regular expressions prefixed with "re:", on paths of nodes as matched by
+// converter.PathFilter
+type patternList []string
+
+// String implements flag.Value
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value, adding a pattern
+func (l *patternList) Set(pattern string) error {
+	if _, err := converter.NewPathFilter([]string{pattern}, nil); err != nil {
 		return err
 	}
+	*l = append(*l, pattern)
+	return nil
+}
+
+// newLogger returns a logger that writes records of level and above to w
+// in format, text or json. Text records leave out the time, which is
+// noise on a console.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid --log-format %q (want text or json)", format)
+}
+
+// console receives the log records of a run. It is stderr when the NX
+// file or JSON progress is written to stdout.
+var console io.Writer = os.Stdout
+
+// convertOptions holds the settings of a conversion run
+type convertOptions struct {
+	converter.Options
 
-	if info.IsDir() {
-		return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+	// Emit lists the outputs written for each input instead of a single
+	// file in Mode. "{name}" in a filename stands for the output path of
+	// the input without its extension, "{mode}" for the mode of the output.
+	Emit []converter.Output
+
+	// OutDir, if set, receives the outputs of each input in a tree that
+	// mirrors the inputs instead of the directory of the input
+	OutDir string
+	// NameTemplate names the NX file of each input: "{name}" stands for
+	// the input name without extension and "{mode}" for the mode
+	NameTemplate string
+	// Existing selects what happens to outputs that already exist
+	Existing existingPolicy
+}
+
+// existingPolicy selects what happens to outputs that already exist
+type existingPolicy int
+
+const (
+	existingFail      existingPolicy = iota // fail the input
+	existingOverwrite                       // replace the file
+	existingSkip                            // keep the file and skip the input
+)
+
+// parseEmit parses an --emit value: comma separated mode=filename pairs
+// where mode is client or server
+func parseEmit(spec string) ([]converter.Output, error) {
+	var outputs []converter.Output
+	for _, part := range strings.Split(spec, ",") {
+		mode, filename, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || filename == "" {
+			return nil, fmt.Errorf("%q is not mode=filename", part)
+		}
+		switch mode {
+		case "client":
+			outputs = append(outputs, converter.Output{Filename: filename, Mode: converter.Client})
+		case "server":
+			outputs = append(outputs, converter.Output{Filename: filename, Mode: converter.Server})
+		default:
+			return nil, fmt.Errorf("unknown mode %q (want client or server)", mode)
+		}
+	}
+	return outputs, nil
+}
+
+// inputPath is an input found on the command line
+type inputPath struct {
+	Path string
+	// Rel is the path relative to the directory named on the command
+	// line, or the file name of a file named there
+	Rel string
+}
+
+// findInputs returns the files under paths with one of the extensions
+// exts, in walk order. Files whose nodes the filter rejects entirely are
+// left out, going by their name without extension. Paths that cannot be
+// walked are reported and skipped.
+func findInputs(paths []string, filter *converter.PathFilter, exts ...string) []inputPath {
+	var inputs []inputPath
+	for _, path := range paths {
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			if !info.IsDir() {
-				return convertFile(p, client, hc, debug)
+			if info.IsDir() {
+				return nil
+			}
+			for _, ext := range exts {
+				if strings.EqualFold(filepath.Ext(p), ext) {
+					if !filter.Enter(rootName(p)) {
+						break
+					}
+					rel, err := filepath.Rel(path, p)
+					if err != nil || rel == "." {
+						rel = filepath.Base(p)
+					}
+					inputs = append(inputs, inputPath{Path: p, Rel: rel})
+					break
+				}
 			}
 			return nil
 		})
+		if err != nil {
+			slog.Error("could not walk input", "path", path, "error", err)
+		}
 	}
+	return inputs
+}
 
-	return convertFile(path, client, hc, debug)
+// fileSize returns the size of a file, or 0 if it cannot be read
+func fileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
 }
 
-func convertFile(filename string, client bool, hc bool, debug bool) error {
-	ext := strings.ToLower(filepath.Ext(filename))
-	if ext != ".wz" && ext != ".img" {
-		return nil
+// convertFile converts a WZ file to an NX file named by opts.NameTemplate,
+// next to the input or at its mirror below opts.OutDir
+func convertFile(ctx context.Context, input inputPath, opts convertOptions) (*converter.Result, error) {
+	dir := filepath.Dir(input.Path)
+	if opts.OutDir != "" {
+		dir = filepath.Join(opts.OutDir, filepath.Dir(input.Rel))
 	}
+	name := strings.TrimSuffix(filepath.Base(input.Path), filepath.Ext(input.Path))
+	nxFilename := filepath.Join(dir, expandName(opts.NameTemplate, name, opts.Mode))
+	run, skip, err := runOptions(opts, input.Path, filepath.Join(dir, name), nxFilename)
+	if skip || err != nil {
+		return &converter.Result{}, err
+	}
+	if opts.OutDir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return convert(ctx, converter.New(input.Path, nxFilename, run))
+}
 
-	nxFilename := strings.TrimSuffix(filename, ext) + ".nx"
-	fmt.Printf("%s -> %s\n", filename, nxFilename)
+// expandName expands a naming template for an input named name
+func expandName(template, name string, mode converter.Mode) string {
+	return strings.NewReplacer("{name}", name, "{mode}", mode.String()).Replace(template)
+}
 
-	converter := NewConverter(filename, nxFilename, client, hc)
-	if debug {
-		logFilename := strings.TrimSuffix(filename, ext) + "_debug.log"
-		if err := converter.EnableDebugLogging(logFilename); err != nil {
-			log.Printf("Warning: Could not enable debug logging: %v\n", err)
+// checkExisting applies policy to the outputs of run that already exist.
+// It reports whether the input is to be skipped because all of its outputs
+// exist and are kept.
+func checkExisting(run *converter.Options, nxFilename string, policy existingPolicy) (bool, error) {
+	if policy == existingOverwrite {
+		return false, nil
+	}
+	outputs := run.Outputs
+	if len(outputs) == 0 {
+		outputs = []converter.Output{{Filename: nxFilename, Mode: run.Mode}}
+	}
+	var keep []converter.Output
+	for _, out := range outputs {
+		if out.Writer != nil {
+			keep = append(keep, out)
+			continue
+		}
+		if _, err := os.Stat(out.Filename); err != nil {
+			keep = append(keep, out)
+			continue
+		}
+		if policy == existingFail {
+			return false, fmt.Errorf("%s already exists (use --overwrite or --skip-existing)", out.Filename)
+		}
+		slog.Info("skipped existing output", "output", out.Filename)
+	}
+	if len(keep) == 0 {
+		return true, nil
+	}
+	if len(keep) < len(outputs) {
+		run.Outputs = keep
+	}
+	return false, nil
+}
+
+// convertTo converts a single WZ file to nxFilename, or to stdout when
+// nxFilename is "-"
+func convertTo(ctx context.Context, filename, nxFilename string, opts convertOptions) (*converter.Result, error) {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	run, skip, err := runOptions(opts, filename, base, nxFilename)
+	if skip || err != nil {
+		return &converter.Result{}, err
+	}
+	return convert(ctx, converter.New(filename, nxFilename, run))
+}
+
+// mergeFiles converts WZ files into one NX file, mounting each under a
+// top-level node named after the file
+func mergeFiles(ctx context.Context, filenames []string, nxFilename string, opts convertOptions) (*converter.Result, error) {
+	base := strings.TrimSuffix(nxFilename, filepath.Ext(nxFilename))
+	run, skip, err := runOptions(opts, strings.Join(filenames, " + "), base, nxFilename)
+	if skip || err != nil {
+		return &converter.Result{}, err
+	}
+	return convert(ctx, converter.NewMerge(filenames, nxFilename, run))
+}
+
+// runOptions returns the converter options for one input. base is the
+// output path without extension, used for "{name}" in --emit filenames.
+// An nxFilename of "-" writes to stdout. skip reports that every output
+// already exists and is kept.
+func runOptions(opts convertOptions, input, base, nxFilename string) (run converter.Options, skip bool, err error) {
+	run = opts.Options
+	run.Outputs = nil
+	for _, out := range opts.Emit {
+		out.Filename = strings.NewReplacer("{name}", base, "{mode}", out.Mode.String()).Replace(out.Filename)
+		run.Outputs = append(run.Outputs, out)
+	}
+	if nxFilename == "-" {
+		run.Outputs = []converter.Output{{Filename: "stdout", Mode: run.Mode, Writer: os.Stdout}}
+	}
+	if skip, err = checkExisting(&run, nxFilename, opts.Existing); skip || err != nil {
+		return run, skip, err
+	}
+	// A file created while the input converts still fails it
+	run.NoClobber = opts.Existing == existingFail
+	if len(run.Outputs) > 0 {
+		names := make([]string, len(run.Outputs))
+		for i, out := range run.Outputs {
+			names[i] = out.Filename
+		}
+		slog.Info("converting", "input", input, "outputs", strings.Join(names, ", "))
+	} else {
+		slog.Info("converting", "input", input, "output", nxFilename)
+	}
+	return run, false, nil
+}
+
+// convert runs a conversion and logs what it wrote
+func convert(ctx context.Context, c *converter.Converter) (*converter.Result, error) {
+	result, err := c.ConvertContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, out := range result.Outputs {
+		slog.Info("wrote", "output", out.Filename, "nodes", out.Nodes, "strings", out.Strings,
+			"bitmaps", out.Bitmaps, "audio", out.Audio, "bytes", out.Size)
+	}
+	if len(result.Warnings) > 0 {
+		slog.Warn("conversion finished with warnings", "warnings", len(result.Warnings))
+	}
+	return result, nil
+}
+
+// failureSummary collects what failed during a run
+type failureSummary struct {
+	inputs   int // inputs that could not be converted at all
+	failures []inputFailure
+}
+
+// inputFailure is an input, or with a Path a part of it, that could not
+// be converted
+type inputFailure struct {
+	input string
+	converter.Failure
+}
+
+// add records the outcome of converting input. Cancellation is not a
+// failure of the input.
+func (s *failureSummary) add(ctx context.Context, input string, result *converter.Result, err error) {
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("conversion failed", "input", input, "error", err)
+			s.inputs++
+			s.failures = append(s.failures, inputFailure{input: input, Failure: converter.Failure{Err: err}})
+		}
+		return
+	}
+	for _, failure := range result.Failures {
+		s.failures = append(s.failures, inputFailure{input: input, Failure: failure})
+	}
+}
+
+// failed reports whether anything failed
+func (s *failureSummary) failed() bool {
+	return len(s.failures) > 0
+}
+
+// report logs every failure and their count. Under best effort they are
+// warnings, otherwise errors.
+func (s *failureSummary) report(logger *slog.Logger, policy converter.ErrorPolicy) {
+	if !s.failed() {
+		return
+	}
+	level := slog.LevelError
+	if policy == converter.BestEffort {
+		level = slog.LevelWarn
+	}
+	ctx := context.Background()
+	for _, f := range s.failures {
+		if f.Path == "" {
+			logger.Log(ctx, level, "failed input", "input", f.input, "error", f.Err)
 		} else {
-			fmt.Printf("Debug logging enabled: %s\n", logFilename)
+			logger.Log(ctx, level, "failed node", "input", f.input, "path", f.Path, "error", f.Err)
 		}
 	}
-	return converter.Convert()
+	logger.Log(ctx, level, "conversion incomplete", "failed_inputs", s.inputs, "failed_nodes", len(s.failures)-s.inputs)
 }
@@ -0,0 +1,190 @@
+// Package nx reads NX (PKG4) files produced by the converter.
+//
+// Files are memory-mapped and never modified, so a File and the Nodes
+// obtained from it are safe for concurrent use by multiple goroutines.
+package nx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// NX file format constants
+const (
+	Magic      = "PKG4"
+	HeaderSize = 52
+	NodeSize   = 20
+)
+
+// Header holds the fixed-size PKG4 header
+type Header struct {
+	NodeCount               uint32 `json:"node_count"`
+	NodeOffset              uint64 `json:"node_offset"`
+	StringCount             uint32 `json:"string_count"`
+	StringOffsetTableOffset uint64 `json:"string_offset_table_offset"`
+	BitmapCount             uint32 `json:"bitmap_count"`
+	BitmapOffsetTableOffset uint64 `json:"bitmap_offset_table_offset"`
+	AudioCount              uint32 `json:"audio_count"`
+	AudioOffsetTableOffset  uint64 `json:"audio_offset_table_offset"`
+}
+
+// File is an opened NX file
+type File struct {
+	Filename string
+	Header   Header
+
+	data    []byte
+	filemap mmap.MMap
+}
+
+// Open memory-maps the named NX file and validates its header
+func Open(filename string) (*File, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < HeaderSize {
+		return nil, fmt.Errorf("%s: file too small for an NX header (%d bytes)", filename, info.Size())
+	}
+
+	filemap, err := mmap.Map(file, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := Load(filemap)
+	if err != nil {
+		filemap.Unmap()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	f.Filename = filename
+	f.filemap = filemap
+	return f, nil
+}
+
+// Load reads an NX file that is already held in memory.
+// The data must not be modified while the File is in use.
+func Load(data []byte) (*File, error) {
+	header, err := ReadHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := header.validate(uint64(len(data))); err != nil {
+		return nil, err
+	}
+	return &File{Header: header, data: data}, nil
+}
+
+// ReadHeader decodes the PKG4 header at the start of data without
+// checking that the tables it describes fit in the file
+func ReadHeader(data []byte) (Header, error) {
+	var h Header
+	if len(data) < HeaderSize {
+		return h, fmt.Errorf("file too small for an NX header (%d bytes)", len(data))
+	}
+	if string(data[0:4]) != Magic {
+		return h, fmt.Errorf("invalid magic %q, want %q", data[0:4], Magic)
+	}
+
+	le := binary.LittleEndian
+	h.NodeCount = le.Uint32(data[4:])
+	h.NodeOffset = le.Uint64(data[8:])
+	h.StringCount = le.Uint32(data[16:])
+	h.StringOffsetTableOffset = le.Uint64(data[20:])
+	h.BitmapCount = le.Uint32(data[28:])
+	h.BitmapOffsetTableOffset = le.Uint64(data[32:])
+	h.AudioCount = le.Uint32(data[40:])
+	h.AudioOffsetTableOffset = le.Uint64(data[44:])
+	return h, nil
+}
+
+// validate checks that every table described by the header lies inside a
+// file of the given size
+func (h Header) validate(size uint64) error {
+	if h.NodeCount == 0 {
+		return errors.New("file has no root node")
+	}
+	if !inBounds(h.NodeOffset, uint64(h.NodeCount)*NodeSize, size) {
+		return fmt.Errorf("node table (%d nodes at %d) exceeds file size %d", h.NodeCount, h.NodeOffset, size)
+	}
+	if !inBounds(h.StringOffsetTableOffset, uint64(h.StringCount)*8, size) {
+		return fmt.Errorf("string offset table (%d strings at %d) exceeds file size %d", h.StringCount, h.StringOffsetTableOffset, size)
+	}
+	if h.BitmapCount > 0 && !inBounds(h.BitmapOffsetTableOffset, uint64(h.BitmapCount)*8, size) {
+		return fmt.Errorf("bitmap offset table (%d bitmaps at %d) exceeds file size %d", h.BitmapCount, h.BitmapOffsetTableOffset, size)
+	}
+	if h.AudioCount > 0 && !inBounds(h.AudioOffsetTableOffset, uint64(h.AudioCount)*8, size) {
+		return fmt.Errorf("audio offset table (%d entries at %d) exceeds file size %d", h.AudioCount, h.AudioOffsetTableOffset, size)
+	}
+	return nil
+}
+
+// inBounds reports whether [offset, offset+length) lies within size bytes
+func inBounds(offset, length, size uint64) bool {
+	return offset <= size && length <= size-offset
+}
+
+// Close releases the memory mapping. Nodes, strings and audio slices
+// obtained from the file must not be used afterwards.
+func (f *File) Close() error {
+	if f.filemap == nil {
+		return nil
+	}
+	err := f.filemap.Unmap()
+	f.filemap = nil
+	f.data = nil
+	return err
+}
+
+// Root returns the root node of the file
+func (f *File) Root() Node {
+	return Node{file: f, index: 0}
+}
+
+// Resolve looks up a slash-separated path from the root node
+func (f *File) Resolve(path string) (Node, bool) {
+	return f.Root().Resolve(path)
+}
+
+// String returns the string with the given ID from the string table
+func (f *File) String(id uint32) (string, bool) {
+	if id >= f.Header.StringCount {
+		return "", false
+	}
+	offset := binary.LittleEndian.Uint64(f.data[f.Header.StringOffsetTableOffset+uint64(id)*8:])
+	size := uint64(len(f.data))
+	if !inBounds(offset, 2, size) {
+		return "", false
+	}
+	length := uint64(binary.LittleEndian.Uint16(f.data[offset:]))
+	if !inBounds(offset+2, length, size) {
+		return "", false
+	}
+	return string(f.data[offset+2 : offset+2+length]), true
+}
+
+// bitmapOffset returns the file offset of the bitmap with the given ID
+func (f *File) bitmapOffset(id uint32) (uint64, bool) {
+	if id >= f.Header.BitmapCount {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(f.data[f.Header.BitmapOffsetTableOffset+uint64(id)*8:]), true
+}
+
+// audioOffset returns the file offset of the audio entry with the given ID
+func (f *File) audioOffset(id uint32) (uint64, bool) {
+	if id >= f.Header.AudioCount {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(f.data[f.Header.AudioOffsetTableOffset+uint64(id)*8:]), true
+}
@@ -0,0 +1,158 @@
+package nx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// buildMinimalFile returns a valid NX file with a single root node and an
+// empty string at index 0
+func buildMinimalFile() []byte {
+	data := make([]byte, HeaderSize+NodeSize+2+8)
+	copy(data, Magic)
+	le := binary.LittleEndian
+	le.PutUint32(data[4:], 1)
+	le.PutUint64(data[8:], HeaderSize)
+	le.PutUint32(data[16:], 1)
+	stringOffset := uint64(HeaderSize + NodeSize)
+	le.PutUint64(data[20:], stringOffset+2)
+	le.PutUint64(data[stringOffset+2:], stringOffset)
+	return data
+}
+
+func TestLoadValidatesHeader(t *testing.T) {
+	if _, err := Load(buildMinimalFile()); err != nil {
+		t.Fatalf("Minimal file rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+		want   string
+	}{
+		{"Short", func(d []byte) []byte { return d[:HeaderSize-1] }, "too small"},
+		{"Magic", func(d []byte) []byte { copy(d, "PKG3"); return d }, "invalid magic"},
+		{"NoNodes", func(d []byte) []byte { binary.LittleEndian.PutUint32(d[4:], 0); return d }, "no root node"},
+		{"NodeTable", func(d []byte) []byte { binary.LittleEndian.PutUint32(d[4:], 50); return d }, "node table"},
+		{"StringTable", func(d []byte) []byte { binary.LittleEndian.PutUint64(d[20:], 1<<40); return d }, "string offset table"},
+		{"BitmapTable", func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[28:], 1)
+			binary.LittleEndian.PutUint64(d[32:], uint64(len(d)))
+			return d
+		}, "bitmap offset table"},
+		{"AudioTable", func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[40:], 1)
+			binary.LittleEndian.PutUint64(d[44:], uint64(len(d)-4))
+			return d
+		}, "audio offset table"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.mutate(buildMinimalFile()))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorruptChildRangeIsClamped(t *testing.T) {
+	data := buildMinimalFile()
+	binary.LittleEndian.PutUint32(data[HeaderSize+4:], 0)
+	binary.LittleEndian.PutUint16(data[HeaderSize+8:], 100)
+
+	file, err := Load(data)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if children := file.Root().Children(); len(children) != 1 {
+		t.Errorf("Expected child range to be clamped to 1 node, got %d", len(children))
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	file, err := Load(buildMinimalFile())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				root := file.Root()
+				if root.Name() != "" || root.Type() != TypeNone || len(root.Children()) != 0 {
+					t.Error("Unexpected root node")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// appendBitmap appends a bitmap table with one width x height bitmap
+// holding pixels to a file built by buildMinimalFile
+func appendBitmap(t *testing.T, data []byte, width, height uint16, pixels []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	w := lz4.NewWriter(&compressed)
+	if _, err := w.Write(pixels); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	table := uint64(len(data))
+	le.PutUint32(data[28:], 1)
+	le.PutUint64(data[32:], table)
+	data = le.AppendUint64(data, table+8)
+	data = le.AppendUint16(data, width)
+	data = le.AppendUint16(data, height)
+	data = le.AppendUint32(data, uint32(compressed.Len()))
+	return append(data, compressed.Bytes()...)
+}
+
+func TestBitmapPixelsChecksSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint16
+		pixels        []byte
+		err           string
+	}{
+		{"Exact", 2, 2, make([]byte, 16), ""},
+		{"Short", 2, 2, make([]byte, 12), "decompresses to 12 bytes, want 16"},
+		{"Long", 2, 2, make([]byte, 20), "decompresses to more than 16 bytes"},
+		{"Huge", 65535, 65535, make([]byte, 16), "larger than the limit"},
+		{"Ratio", 4096, 4096, make([]byte, 16), "compressed bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := Load(appendBitmap(t, buildMinimalFile(), tt.width, tt.height, tt.pixels))
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			pixels, err := file.BitmapPixels(0)
+			if tt.err == "" {
+				if err != nil || len(pixels) != len(tt.pixels) {
+					t.Errorf("BitmapPixels = %d bytes, %v", len(pixels), err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("BitmapPixels error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package nx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// NodeType identifies the kind of data a node carries
+type NodeType uint16
+
+// Node types
+const (
+	TypeNone   NodeType = 0
+	TypeInt64  NodeType = 1
+	TypeDouble NodeType = 2
+	TypeString NodeType = 3
+	TypePoint  NodeType = 4
+	TypeBitmap NodeType = 5
+	TypeAudio  NodeType = 6
+)
+
+// String returns the name of the node type
+func (t NodeType) String() string {
+	switch t {
+	case TypeNone:
+		return "none"
+	case TypeInt64:
+		return "int64"
+	case TypeDouble:
+		return "double"
+	case TypeString:
+		return "string"
+	case TypePoint:
+		return "point"
+	case TypeBitmap:
+		return "bitmap"
+	case TypeAudio:
+		return "audio"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint16(t))
+	}
+}
+
+// Node is a lightweight handle to an entry of the node table.
+// The zero value is not a valid node.
+type Node struct {
+	file  *File
+	index uint32
+}
+
+// Bitmap describes the bitmap referenced by a bitmap node
+type Bitmap struct {
+	ID     uint32
+	Width  uint16
+	Height uint16
+}
+
+// Audio describes the audio entry referenced by an audio node
+type Audio struct {
+	ID     uint32
+	Length uint32
+}
+
+// record returns the raw 20-byte node record
+func (n Node) record() []byte {
+	offset := n.file.Header.NodeOffset + uint64(n.index)*NodeSize
+	return n.file.data[offset : offset+NodeSize]
+}
+
+// Index returns the position of the node in the node table
+func (n Node) Index() uint32 {
+	return n.index
+}
+
+// Name returns the node name
+func (n Node) Name() string {
+	name, _ := n.file.String(binary.LittleEndian.Uint32(n.record()[0:]))
+	return name
+}
+
+// Type returns the node type
+func (n Node) Type() NodeType {
+	return NodeType(binary.LittleEndian.Uint16(n.record()[10:]))
+}
+
+// ChildCount returns the number of children
+func (n Node) ChildCount() int {
+	return int(binary.LittleEndian.Uint16(n.record()[8:]))
+}
+
+// childRange returns the first child index and child count, clamped to
+// the node table so that corrupt files cannot cause out-of-range reads
+func (n Node) childRange() (uint32, uint32) {
+	rec := n.record()
+	first := binary.LittleEndian.Uint32(rec[4:])
+	count := uint32(binary.LittleEndian.Uint16(rec[8:]))
+	total := n.file.Header.NodeCount
+	if first >= total {
+		return 0, 0
+	}
+	if count > total-first {
+		count = total - first
+	}
+	return first, count
+}
+
+// Children returns the children of the node in file order
+func (n Node) Children() []Node {
+	first, count := n.childRange()
+	children := make([]Node, count)
+	for i := range children {
+		children[i] = Node{file: n.file, index: first + uint32(i)}
+	}
+	return children
+}
+
+// Child returns the first child with the given name
+func (n Node) Child(name string) (Node, bool) {
+	first, count := n.childRange()
+	for i := uint32(0); i < count; i++ {
+		child := Node{file: n.file, index: first + i}
+		if child.Name() == name {
+			return child, true
+		}
+	}
+	return Node{}, false
+}
+
+// Resolve looks up a slash-separated path relative to the node, such as
+// "Map/Map1/100000000.img/info/bgm". Empty path segments are ignored.
+func (n Node) Resolve(path string) (Node, bool) {
+	current := n
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		child, ok := current.Child(name)
+		if !ok {
+			return Node{}, false
+		}
+		current = child
+	}
+	return current, true
+}
+
+// Int returns the value of an int64 node
+func (n Node) Int() (int64, bool) {
+	if n.Type() != TypeInt64 {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(n.record()[12:])), true
+}
+
+// Double returns the value of a double node
+func (n Node) Double() (float64, bool) {
+	if n.Type() != TypeDouble {
+		return 0, false
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(n.record()[12:])), true
+}
+
+// Str returns the value of a string node
+func (n Node) Str() (string, bool) {
+	if n.Type() != TypeString {
+		return "", false
+	}
+	return n.file.String(binary.LittleEndian.Uint32(n.record()[12:]))
+}
+
+// Point returns the value of a point node
+func (n Node) Point() (x, y int32, ok bool) {
+	if n.Type() != TypePoint {
+		return 0, 0, false
+	}
+	rec := n.record()
+	return int32(binary.LittleEndian.Uint32(rec[12:])), int32(binary.LittleEndian.Uint32(rec[16:])), true
+}
+
+// Bitmap returns the bitmap reference of a bitmap node
+func (n Node) Bitmap() (Bitmap, bool) {
+	if n.Type() != TypeBitmap {
+		return Bitmap{}, false
+	}
+	rec := n.record()
+	return Bitmap{
+		ID:     binary.LittleEndian.Uint32(rec[12:]),
+		Width:  binary.LittleEndian.Uint16(rec[16:]),
+		Height: binary.LittleEndian.Uint16(rec[18:]),
+	}, true
+}
+
+// Audio returns the audio reference of an audio node
+func (n Node) Audio() (Audio, bool) {
+	if n.Type() != TypeAudio {
+		return Audio{}, false
+	}
+	rec := n.record()
+	return Audio{
+		ID:     binary.LittleEndian.Uint32(rec[12:]),
+		Length: binary.LittleEndian.Uint32(rec[16:]),
+	}, true
+}
+
+// Pixels returns the decompressed RGBA pixel data of a bitmap node
+func (n Node) Pixels() ([]byte, error) {
+	bitmap, ok := n.Bitmap()
+	if !ok {
+		return nil, fmt.Errorf("node %q is not a bitmap", n.Name())
+	}
+	return n.file.BitmapPixels(bitmap.ID)
+}
+
+// Image decodes a bitmap node into an image
+func (n Node) Image() (image.Image, error) {
+	bitmap, ok := n.Bitmap()
+	if !ok {
+		return nil, fmt.Errorf("node %q is not a bitmap", n.Name())
+	}
+	pixels, err := n.file.BitmapPixels(bitmap.ID)
+	if err != nil {
+		return nil, err
+	}
+	width, height := int(bitmap.Width), int(bitmap.Height)
+	if len(pixels) != width*height*4 {
+		return nil, fmt.Errorf("bitmap %d has %d bytes, want %d for %dx%d", bitmap.ID, len(pixels), width*height*4, width, height)
+	}
+	return &image.NRGBA{
+		Pix:    pixels,
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}, nil
+}
+
+// AudioData returns the raw audio bytes of an audio node. The returned
+// slice aliases the file and must not be modified.
+func (n Node) AudioData() ([]byte, error) {
+	audio, ok := n.Audio()
+	if !ok {
+		return nil, fmt.Errorf("node %q is not audio", n.Name())
+	}
+	return n.file.AudioData(audio.ID, audio.Length)
+}
+
+// maxBitmapBytes bounds the pixels of a single bitmap, enough for
+// 8192x8192 RGBA. Larger dimensions only come from corrupt files.
+const maxBitmapBytes = 8192 * 8192 * 4
+
+// maxLZ4Ratio is the most LZ4 can expand its input
+const maxLZ4Ratio = 255
+
+// BitmapPixels decompresses the bitmap with the given ID. Bitmaps that are
+// too large or do not decompress to exactly width*height*4 bytes are
+// rejected.
+//
+// Each bitmap entry is stored as a uint16 width, a uint16 height, a uint32
+// compressed size and an LZ4 frame holding RGBA pixels.
+func (f *File) BitmapPixels(id uint32) ([]byte, error) {
+	offset, ok := f.bitmapOffset(id)
+	if !ok {
+		return nil, fmt.Errorf("bitmap ID %d out of range (%d bitmaps)", id, f.Header.BitmapCount)
+	}
+	size := uint64(len(f.data))
+	if !inBounds(offset, 8, size) {
+		return nil, fmt.Errorf("bitmap %d offset %d exceeds file size %d", id, offset, size)
+	}
+	width := int(binary.LittleEndian.Uint16(f.data[offset:]))
+	height := int(binary.LittleEndian.Uint16(f.data[offset+2:]))
+	length := uint64(binary.LittleEndian.Uint32(f.data[offset+4:]))
+	if !inBounds(offset+8, length, size) {
+		return nil, fmt.Errorf("bitmap %d data (%d bytes at %d) exceeds file size %d", id, length, offset+8, size)
+	}
+
+	want := uint64(width) * uint64(height) * 4
+	if want > maxBitmapBytes {
+		return nil, fmt.Errorf("bitmap %d is %dx%d, larger than the limit of %d bytes", id, width, height, maxBitmapBytes)
+	}
+	if want > length*maxLZ4Ratio {
+		return nil, fmt.Errorf("bitmap %d is %dx%d but has only %d compressed bytes", id, width, height, length)
+	}
+
+	// Read one byte more than expected to tell a long bitmap from an exact one
+	compressed := f.data[offset+8 : offset+8+length]
+	pixels := make([]byte, want+1)
+	n, err := io.ReadFull(io.LimitReader(lz4.NewReader(bytes.NewReader(compressed)), int64(want)+1), pixels)
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("bitmap %d decompresses to more than %d bytes for %dx%d", id, want, width, height)
+	case err != io.EOF && err != io.ErrUnexpectedEOF:
+		return nil, fmt.Errorf("decompressing bitmap %d: %w", id, err)
+	case uint64(n) != want:
+		return nil, fmt.Errorf("bitmap %d decompresses to %d bytes, want %d for %dx%d", id, n, want, width, height)
+	}
+	return pixels[:n], nil
+}
+
+// AudioData returns length bytes of the audio entry with the given ID.
+// The returned slice aliases the file and must not be modified.
+func (f *File) AudioData(id uint32, length uint32) ([]byte, error) {
+	offset, ok := f.audioOffset(id)
+	if !ok {
+		return nil, fmt.Errorf("audio ID %d out of range (%d entries)", id, f.Header.AudioCount)
+	}
+	if !inBounds(offset, uint64(length), uint64(len(f.data))) {
+		return nil, fmt.Errorf("audio %d data (%d bytes at %d) exceeds file size %d", id, length, offset, len(f.data))
+	}
+	return f.data[offset : offset+uint64(length)], nil
+}
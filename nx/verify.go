@@ -0,0 +1,276 @@
+package nx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// MaxProblems caps the number of problems collected for a single file so
+// that a badly corrupted file still produces a readable report
+const MaxProblems = 1000
+
+// Problem describes a single structural defect found by Verify
+type Problem struct {
+	Check   string  `json:"check"`
+	Node    *uint32 `json:"node,omitempty"`
+	Message string  `json:"message"`
+}
+
+// Report is the result of verifying an NX file
+type Report struct {
+	File      string    `json:"file,omitempty"`
+	Size      int64     `json:"size"`
+	Valid     bool      `json:"valid"`
+	Header    *Header   `json:"header,omitempty"`
+	Problems  []Problem `json:"problems"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// addf records a problem, optionally tied to a node index
+func (r *Report) addf(check string, node *uint32, format string, args ...interface{}) {
+	if len(r.Problems) >= MaxProblems {
+		r.Truncated = true
+		return
+	}
+	r.Problems = append(r.Problems, Problem{Check: check, Node: node, Message: fmt.Sprintf(format, args...)})
+}
+
+// VerifyFile memory-maps the named file and verifies it
+func VerifyFile(filename string) (*Report, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var report *Report
+	if info.Size() == 0 {
+		report = Verify(nil)
+	} else {
+		filemap, err := mmap.Map(file, mmap.RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer filemap.Unmap()
+		report = Verify(filemap)
+	}
+	report.File = filename
+	return report, nil
+}
+
+// Verify structurally validates an NX file held in memory. It checks the
+// header, that every table lies inside the file, that child ranges, string,
+// bitmap and audio IDs are in range, that every bitmap decompresses to
+// width*height*4 bytes and that the node graph has no cycles or orphans.
+func Verify(data []byte) *Report {
+	report := &Report{Size: int64(len(data)), Problems: []Problem{}}
+	defer func() { report.Valid = len(report.Problems) == 0 }()
+
+	header, err := ReadHeader(data)
+	if err != nil {
+		report.addf("header", nil, "%v", err)
+		return report
+	}
+	report.Header = &header
+	size := uint64(len(data))
+	f := &File{Header: header, data: data}
+
+	if header.NodeCount == 0 {
+		report.addf("header", nil, "file has no root node")
+		return report
+	}
+	if !inBounds(header.NodeOffset, uint64(header.NodeCount)*NodeSize, size) {
+		report.addf("bounds", nil, "node table (%d nodes at %d) exceeds file size %d", header.NodeCount, header.NodeOffset, size)
+		return report
+	}
+	stringsOK := inBounds(header.StringOffsetTableOffset, uint64(header.StringCount)*8, size)
+	if !stringsOK {
+		report.addf("bounds", nil, "string offset table (%d strings at %d) exceeds file size %d", header.StringCount, header.StringOffsetTableOffset, size)
+	}
+	bitmapsOK := inBounds(header.BitmapOffsetTableOffset, uint64(header.BitmapCount)*8, size)
+	if !bitmapsOK {
+		report.addf("bounds", nil, "bitmap offset table (%d bitmaps at %d) exceeds file size %d", header.BitmapCount, header.BitmapOffsetTableOffset, size)
+	}
+	audioOK := inBounds(header.AudioOffsetTableOffset, uint64(header.AudioCount)*8, size)
+	if !audioOK {
+		report.addf("bounds", nil, "audio offset table (%d entries at %d) exceeds file size %d", header.AudioCount, header.AudioOffsetTableOffset, size)
+	}
+
+	if stringsOK {
+		verifyStrings(f, report)
+	}
+	childRangesOK := verifyNodes(f, report, bitmapsOK, audioOK)
+	if bitmapsOK {
+		verifyBitmaps(f, report)
+	}
+	if childRangesOK {
+		verifyGraph(f, report)
+	}
+
+	return report
+}
+
+// verifyStrings checks that every string entry lies inside the file
+func verifyStrings(f *File, report *Report) {
+	size := uint64(len(f.data))
+	for id := uint32(0); id < f.Header.StringCount; id++ {
+		offset := binary.LittleEndian.Uint64(f.data[f.Header.StringOffsetTableOffset+uint64(id)*8:])
+		if !inBounds(offset, 2, size) {
+			report.addf("string", nil, "string %d offset %d exceeds file size %d", id, offset, size)
+			continue
+		}
+		length := uint64(binary.LittleEndian.Uint16(f.data[offset:]))
+		if !inBounds(offset+2, length, size) {
+			report.addf("string", nil, "string %d (%d bytes at %d) exceeds file size %d", id, length, offset+2, size)
+		}
+	}
+}
+
+// verifyNodes checks every node record and reports whether all child
+// ranges lie inside the node table
+func verifyNodes(f *File, report *Report, bitmapsOK, audioOK bool) bool {
+	le := binary.LittleEndian
+	size := uint64(len(f.data))
+	childRangesOK := true
+
+	for i := uint32(0); i < f.Header.NodeCount; i++ {
+		index := i
+		node := Node{file: f, index: i}
+		rec := node.record()
+
+		if nameID := le.Uint32(rec[0:]); nameID >= f.Header.StringCount {
+			report.addf("string_id", &index, "name string ID %d out of range (%d strings)", nameID, f.Header.StringCount)
+		}
+
+		first := uint64(le.Uint32(rec[4:]))
+		count := uint64(le.Uint16(rec[8:]))
+		if count > 0 && first+count > uint64(f.Header.NodeCount) {
+			report.addf("child_range", &index, "children [%d, %d) exceed node table (%d nodes)", first, first+count, f.Header.NodeCount)
+			childRangesOK = false
+		}
+
+		switch node.Type() {
+		case TypeNone, TypeInt64, TypeDouble, TypePoint:
+		case TypeString:
+			if id := le.Uint32(rec[12:]); id >= f.Header.StringCount {
+				report.addf("string_id", &index, "string value ID %d out of range (%d strings)", id, f.Header.StringCount)
+			}
+		case TypeBitmap:
+			bitmap, _ := node.Bitmap()
+			if bitmap.ID >= f.Header.BitmapCount {
+				report.addf("bitmap_id", &index, "bitmap ID %d out of range (%d bitmaps)", bitmap.ID, f.Header.BitmapCount)
+			} else if bitmapsOK {
+				offset, _ := f.bitmapOffset(bitmap.ID)
+				if inBounds(offset, 4, size) && (le.Uint16(f.data[offset:]) != bitmap.Width || le.Uint16(f.data[offset+2:]) != bitmap.Height) {
+					report.addf("bitmap_size", &index, "node is %dx%d but bitmap %d is %dx%d",
+						bitmap.Width, bitmap.Height, bitmap.ID, le.Uint16(f.data[offset:]), le.Uint16(f.data[offset+2:]))
+				}
+			}
+		case TypeAudio:
+			audio, _ := node.Audio()
+			if audio.ID >= f.Header.AudioCount {
+				report.addf("audio_id", &index, "audio ID %d out of range (%d entries)", audio.ID, f.Header.AudioCount)
+			} else if audioOK {
+				if _, err := f.AudioData(audio.ID, audio.Length); err != nil {
+					report.addf("audio", &index, "%v", err)
+				}
+			}
+		default:
+			report.addf("type", &index, "unknown node type %d", uint16(node.Type()))
+		}
+	}
+
+	return childRangesOK
+}
+
+// verifyBitmaps decompresses every bitmap in parallel and checks that it
+// holds width*height*4 bytes. BitmapPixels rejects oversize bitmaps before
+// allocating them.
+func verifyBitmaps(f *File, report *Report) {
+	count := f.Header.BitmapCount
+	if count == 0 {
+		return
+	}
+	results := make([]string, count)
+	ids := make(chan uint32)
+	var wg sync.WaitGroup
+
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				if _, err := f.BitmapPixels(id); err != nil {
+					results[id] = err.Error()
+				}
+			}
+		}()
+	}
+	for id := uint32(0); id < count; id++ {
+		ids <- id
+	}
+	close(ids)
+	wg.Wait()
+
+	for _, result := range results {
+		if result != "" {
+			report.addf("bitmap", nil, "%s", result)
+		}
+	}
+}
+
+// verifyGraph walks the node graph from the root and reports cycles and
+// nodes that cannot be reached. Several parents may share one child range.
+func verifyGraph(f *File, report *Report) {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make([]uint8, f.Header.NodeCount)
+
+	type frame struct {
+		node Node
+		next uint32
+	}
+	stack := []frame{{node: f.Root()}}
+	state[0] = active
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		first, count := top.node.childRange()
+		if top.next >= count {
+			state[top.node.index] = done
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		child := first + top.next
+		top.next++
+
+		switch state[child] {
+		case unvisited:
+			state[child] = active
+			stack = append(stack, frame{node: Node{file: f, index: child}})
+		case active:
+			parent := top.node.index
+			report.addf("cycle", &parent, "child %d is an ancestor of node %d", child, parent)
+		}
+	}
+
+	for i, s := range state {
+		if s == unvisited {
+			index := uint32(i)
+			report.addf("orphan", &index, "node is not reachable from the root")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package nx
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildTwoNodeFile returns a valid NX file whose root has a single child
+func buildTwoNodeFile() []byte {
+	data := make([]byte, HeaderSize+2*NodeSize+2+8)
+	copy(data, Magic)
+	le := binary.LittleEndian
+	le.PutUint32(data[4:], 2)
+	le.PutUint64(data[8:], HeaderSize)
+	le.PutUint32(data[16:], 1)
+	stringOffset := uint64(HeaderSize + 2*NodeSize)
+	le.PutUint64(data[20:], stringOffset+2)
+	le.PutUint64(data[stringOffset+2:], stringOffset)
+
+	// Root: children [1, 2)
+	le.PutUint32(data[HeaderSize+4:], 1)
+	le.PutUint16(data[HeaderSize+8:], 1)
+	return data
+}
+
+// hasCheck reports whether the report contains a problem of the given kind
+func hasCheck(report *Report, check string) bool {
+	for _, p := range report.Problems {
+		if p.Check == check {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyValidFile(t *testing.T) {
+	report := Verify(buildTwoNodeFile())
+	if !report.Valid {
+		t.Errorf("Valid file reported problems: %+v", report.Problems)
+	}
+}
+
+func TestVerifyDetectsProblems(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+		check  string
+	}{
+		{"Magic", func(d []byte) []byte { copy(d, "PKG1"); return d }, "header"},
+		{"NodeTable", func(d []byte) []byte { le.PutUint64(d[8:], uint64(len(d))); return d }, "bounds"},
+		{"ChildRange", func(d []byte) []byte { le.PutUint16(d[HeaderSize+8:], 5); return d }, "child_range"},
+		{"NameID", func(d []byte) []byte { le.PutUint32(d[HeaderSize+NodeSize:], 9); return d }, "string_id"},
+		{"StringValueID", func(d []byte) []byte {
+			le.PutUint16(d[HeaderSize+NodeSize+10:], uint16(TypeString))
+			le.PutUint32(d[HeaderSize+NodeSize+12:], 3)
+			return d
+		}, "string_id"},
+		{"BitmapID", func(d []byte) []byte { le.PutUint16(d[HeaderSize+NodeSize+10:], uint16(TypeBitmap)); return d }, "bitmap_id"},
+		{"AudioID", func(d []byte) []byte { le.PutUint16(d[HeaderSize+NodeSize+10:], uint16(TypeAudio)); return d }, "audio_id"},
+		{"Type", func(d []byte) []byte { le.PutUint16(d[HeaderSize+NodeSize+10:], 42); return d }, "type"},
+		{"Cycle", func(d []byte) []byte {
+			// Child points back at the root
+			le.PutUint32(d[HeaderSize+NodeSize+4:], 0)
+			le.PutUint16(d[HeaderSize+NodeSize+8:], 1)
+			return d
+		}, "cycle"},
+		{"Orphan", func(d []byte) []byte { le.PutUint16(d[HeaderSize+8:], 0); return d }, "orphan"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Verify(tt.mutate(buildTwoNodeFile()))
+			if report.Valid || !hasCheck(report, tt.check) {
+				t.Errorf("Expected a %q problem, got %+v", tt.check, report.Problems)
+			}
+		})
+	}
+}
+
+func TestVerifyAcceptsSharedChildRanges(t *testing.T) {
+	data := make([]byte, HeaderSize+3*NodeSize+2+8)
+	copy(data, Magic)
+	le := binary.LittleEndian
+	le.PutUint32(data[4:], 3)
+	le.PutUint64(data[8:], HeaderSize)
+	le.PutUint32(data[16:], 1)
+	stringOffset := uint64(HeaderSize + 3*NodeSize)
+	le.PutUint64(data[20:], stringOffset+2)
+	le.PutUint64(data[stringOffset+2:], stringOffset)
+
+	// Root has children 1 and 2, and node 1 lists node 2 as its child too
+	le.PutUint32(data[HeaderSize+4:], 1)
+	le.PutUint16(data[HeaderSize+8:], 2)
+	le.PutUint32(data[HeaderSize+NodeSize+4:], 2)
+	le.PutUint16(data[HeaderSize+NodeSize+8:], 1)
+
+	if report := Verify(data); !report.Valid {
+		t.Errorf("Shared child range reported problems: %+v", report.Problems)
+	}
+}
+
+func TestVerifyReportsOversizeBitmaps(t *testing.T) {
+	if report := Verify(appendBitmap(t, buildMinimalFile(), 2, 2, make([]byte, 16))); !report.Valid {
+		t.Errorf("Valid bitmap reported problems: %+v", report.Problems)
+	}
+
+	report := Verify(appendBitmap(t, buildMinimalFile(), 65535, 65535, make([]byte, 16)))
+	if report.Valid || !hasCheck(report, "bitmap") {
+		t.Errorf("Expected a bitmap problem, got %+v", report.Problems)
+	}
+}
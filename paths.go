@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// safeName returns a node name that is safe to use as a single file name.
+// Node names come from the input file and may be anything, so backslashes,
+// volume names and the names "", "." and ".." are escaped.
+func safeName(name string) string {
+	name = strings.ReplaceAll(name, `\`, "_")
+	if volume := filepath.VolumeName(name); volume != "" {
+		name = strings.ReplaceAll(volume, ":", "_") + name[len(volume):]
+	}
+	switch name {
+	case "", ".", "..":
+		return "_" + name
+	}
+	return name
+}
+
+// safePath passes every name of a "/"-separated node path through
+// safeName
+func safePath(nodePath string) string {
+	names := strings.Split(nodePath, "/")
+	for i, name := range names {
+		names[i] = safeName(name)
+	}
+	return strings.Join(names, "/")
+}
+
+// outputPath returns the file for a "/"-separated node path below dir,
+// with ext appended. The path is made safe by safePath, and a path that
+// would still leave dir is rejected.
+func outputPath(dir, nodePath, ext string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(safePath(nodePath))+ext)
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("node path %q leaves the output directory %s", nodePath, dir)
+	}
+	return target, nil
+}
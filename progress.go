@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+)
+
+// progressReporter renders the progress of a run of conversions
+type progressReporter interface {
+	// startInput announces the next conversion of the run, named after its
+	// input and weighted by its size
+	startInput(name string, size int64)
+	// report receives the progress events of the current conversion
+	report(p converter.Progress)
+	// finish ends the run
+	finish()
+}
+
+// newProgressReporter returns the reporter for a --progress mode, writing
+// to w. files and totalBytes describe the whole run. The auto mode shows a
+// bar when w is a terminal and nothing otherwise.
+func newProgressReporter(mode string, w io.Writer, files int, totalBytes int64) (progressReporter, error) {
+	if mode == "auto" {
+		mode = "none"
+		if isTerminal(w) {
+			mode = "bar"
+		}
+	}
+	switch mode {
+	case "bar":
+		return &progressBar{w: w, files: files, totalBytes: totalBytes, started: time.Now()}, nil
+	case "json":
+		return &jsonProgress{enc: json.NewEncoder(w), files: files}, nil
+	case "none":
+		return noProgress{}, nil
+	}
+	return nil, fmt.Errorf("unknown progress mode %q (want auto, bar, json or none)", mode)
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// noProgress discards progress
+type noProgress struct{}
+
+func (noProgress) startInput(string, int64)    {}
+func (noProgress) report(p converter.Progress) {}
+func (noProgress) finish()                     {}
+
+// stageWeights estimates the share of a conversion taken by each stage, in
+// stage order. They only shape the estimate of the remaining time.
+var stageWeights = []struct {
+	stage  converter.Stage
+	weight float64
+}{
+	{converter.StageParse, 0.05},
+	{converter.StageTraverse, 0.5},
+	{converter.StageCompress, 0.3},
+	{converter.StageWrite, 0.15},
+}
+
+// progressBar draws a single line with the progress of the whole run and
+// an estimate of the remaining time. Messages written through messages
+// clear the bar, are printed and the bar is drawn again.
+type progressBar struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	files      int
+	totalBytes int64
+	started    time.Time
+
+	file      int    // 1-based index of the current input
+	name      string // current input
+	size      int64  // size of the current input
+	doneBytes int64  // size of the finished inputs
+
+	stage    converter.Stage
+	fraction float64 // of the current input, never decreasing
+
+	shown    int // width of the drawn line, 0 when none is shown
+	lastDraw time.Time
+	finished bool
+}
+
+func (b *progressBar) startInput(name string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file > 0 {
+		b.doneBytes += b.size
+	}
+	b.file++
+	b.name, b.size = name, size
+	b.stage, b.fraction = "", 0
+}
+
+func (b *progressBar) report(p converter.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The fraction of the input reached by this event
+	var offset float64
+	for _, sw := range stageWeights {
+		if sw.stage == p.Stage {
+			stage := 1.0
+			if p.Total > 0 {
+				stage = float64(p.Done) / float64(p.Total)
+			}
+			if f := offset + sw.weight*stage; f > b.fraction {
+				b.fraction = f
+			}
+			break
+		}
+		offset += sw.weight
+	}
+	b.stage = p.Stage
+
+	// Redraw at most ten times a second, except when stages change
+	if p.Event == converter.EventProgress && time.Since(b.lastDraw) < 100*time.Millisecond {
+		return
+	}
+	b.draw()
+}
+
+// messages returns a writer that prints to w above the bar
+func (b *progressBar) messages(w io.Writer) io.Writer {
+	return barMessages{bar: b, w: w}
+}
+
+// barMessages prints messages above a progress bar
+type barMessages struct {
+	bar *progressBar
+	w   io.Writer
+}
+
+func (m barMessages) Write(p []byte) (int, error) {
+	b := m.bar
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := m.w.Write(p)
+	if b.file > 0 && !b.finished && strings.HasSuffix(string(p), "\n") {
+		b.draw()
+	}
+	return n, err
+}
+
+func (b *progressBar) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.finished = true
+}
+
+// overall returns the fraction of the run that is done
+func (b *progressBar) overall() float64 {
+	if b.totalBytes > 0 {
+		return (float64(b.doneBytes) + float64(b.size)*b.fraction) / float64(b.totalBytes)
+	}
+	if b.files > 0 {
+		return (float64(b.file-1) + b.fraction) / float64(b.files)
+	}
+	return 0
+}
+
+// draw draws the bar in place of the current line
+func (b *progressBar) draw() {
+	const width = 30
+	overall := b.overall()
+	filled := int(overall * width)
+	if filled > width {
+		filled = width
+	}
+
+	eta := "ETA --"
+	if elapsed := time.Since(b.started); overall > 0.01 {
+		remaining := time.Duration(float64(elapsed) * (1 - overall) / overall)
+		eta = "ETA " + remaining.Round(time.Second).String()
+	}
+
+	line := fmt.Sprintf("[%d/%d] %s %s [%s%s] %3d%% %s", b.file, b.files, b.name, b.stage,
+		strings.Repeat("=", filled), strings.Repeat(" ", width-filled), int(overall*100), eta)
+	pad := ""
+	if len(line) < b.shown {
+		pad = strings.Repeat(" ", b.shown-len(line))
+	}
+	fmt.Fprintf(b.w, "\r%s%s", line, pad)
+	b.shown = len(line)
+	b.lastDraw = time.Now()
+}
+
+// clear removes the bar from the current line
+func (b *progressBar) clear() {
+	if b.shown > 0 {
+		fmt.Fprintf(b.w, "\r%s\r", strings.Repeat(" ", b.shown))
+		b.shown = 0
+	}
+}
+
+// jsonProgress writes every progress event as one line of JSON
+type jsonProgress struct {
+	enc   *json.Encoder
+	files int
+	file  int
+	input string
+}
+
+// progressEvent is the JSON form of a progress event
+type progressEvent struct {
+	Time   time.Time       `json:"time"`
+	Event  converter.Event `json:"event"`
+	Stage  converter.Stage `json:"stage"`
+	Input  string          `json:"input"`
+	Output string          `json:"output,omitempty"`
+	File   int             `json:"file"`
+	Files  int             `json:"files"`
+	Done   int64           `json:"done"`
+	Total  int64           `json:"total"`
+}
+
+func (j *jsonProgress) startInput(name string, size int64) {
+	j.file++
+	j.input = name
+}
+
+func (j *jsonProgress) report(p converter.Progress) {
+	j.enc.Encode(progressEvent{
+		Time:   time.Now().UTC(),
+		Event:  p.Event,
+		Stage:  p.Stage,
+		Input:  j.input,
+		Output: p.Output,
+		File:   j.file,
+		Files:  j.files,
+		Done:   p.Done,
+		Total:  p.Total,
+	})
+}
+
+func (j *jsonProgress) finish() {}
@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/converter"
+	"github.com/ErwinsExpertise/go-wztonx-converter/internal/wztest"
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+	"github.com/ErwinsExpertise/go-wztonx-converter/wz"
+)
+
+// convertTestBytes converts a WZ tree and returns the NX data
+func convertTestBytes(t *testing.T, dir *wz.WZDirectory, opts converter.Options) ([]byte, *converter.Result) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.nx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	result, err := converter.ConvertDirectory(dir, f, opts)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, result
+}
+
+// convertTestDirectory converts a WZ tree and loads the result
+func convertTestDirectory(t *testing.T, dir *wz.WZDirectory, opts converter.Options) *nx.File {
+	t.Helper()
+	data, _ := convertTestBytes(t, dir, opts)
+	file, err := nx.Load(data)
+	if err != nil {
+		t.Fatalf("Failed to load NX data: %v", err)
+	}
+	return file
+}
+
+func TestCompareFollowsUOLPolicy(t *testing.T) {
+	// The converter drops Convex2D shapes and converts UOLs by its policy;
+	// everything else must round-trip exactly
+	for _, policy := range []converter.UOLPolicy{converter.UOLDrop, converter.UOLString, converter.UOLResolve} {
+		dir := wztest.BuildDirectory()
+		file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client, UOLs: policy})
+
+		cmp := &comparer{opts: compareOptions{UOLs: policy}}
+		cmp.compareDirectory(dir, file.Root(), "")
+		for _, m := range cmp.mismatches {
+			t.Errorf("UOL policy %d: unexpected mismatch %s: %s", policy, m.Path, m.Message)
+		}
+	}
+
+	// Expecting link paths where UOLs were dropped reports the link
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client})
+	cmp := &comparer{opts: compareOptions{UOLs: converter.UOLString}}
+	cmp.compareDirectory(dir, file.Root(), "")
+	if len(cmp.mismatches) != 1 || cmp.mismatches[0].Path != "Mob/100100.img/link" {
+		t.Errorf("Expected a mismatch at the link, got %+v", cmp.mismatches)
+	}
+}
+
+func TestCompareServerMode(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{})
+
+	cmp := &comparer{opts: compareOptions{Server: true}}
+	cmp.compareDirectory(dir, file.Root(), "")
+	for _, m := range cmp.mismatches {
+		t.Errorf("Unexpected mismatch %s: %s", m.Path, m.Message)
+	}
+
+	// Comparing server output in client mode must flag the missing media
+	cmp = &comparer{}
+	cmp.compareDirectory(dir, file.Root(), "")
+	if len(cmp.mismatches) != 2 {
+		t.Errorf("Expected 2 mismatches in client mode, got %d: %+v", len(cmp.mismatches), cmp.mismatches)
+	}
+}
+
+func TestCompareDetectsValueChanges(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client})
+
+	info := dir.Directory("Mob").Image("100100.img").Properties.Get("info").Value.(*wz.WZProperty)
+	info.Get("maxHP").Value = int32(9)
+	info.Get("name").Value = "Blue Snail"
+
+	cmp := &comparer{}
+	cmp.compareDirectory(dir, file.Root(), "")
+
+	found := map[string]bool{}
+	for _, m := range cmp.mismatches {
+		found[m.Path] = true
+	}
+	if !found["Mob/100100.img/info/maxHP"] || !found["Mob/100100.img/info/name"] {
+		t.Errorf("Value changes were not reported: %+v", cmp.mismatches)
+	}
+}
+
+func TestExportWZJSON(t *testing.T) {
+	var buf bytes.Buffer
+	e := &jsonExporter{opts: exportOptions{Bitmaps: mediaBase64, Audio: mediaOmit}, w: newJSONWriter(&buf, true)}
+	if err := e.writeWZDirectory(wztest.BuildDirectory(), ""); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := e.w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Export is not valid JSON: %v\n%s", err, buf.String())
+	}
+
+	// Keys must keep file order
+	if !strings.Contains(buf.String(), `"Mob": {`) || strings.Index(buf.String(), `"Mob"`) > strings.Index(buf.String(), `"Sound.img"`) {
+		t.Errorf("Directories must precede images:\n%s", buf.String())
+	}
+	if strings.Index(buf.String(), `"maxHP"`) > strings.Index(buf.String(), `"rate"`) {
+		t.Errorf("Property order was not preserved")
+	}
+
+	img := doc["Mob"].(map[string]interface{})["100100.img"].(map[string]interface{})
+	info := img["info"].(map[string]interface{})
+	if info["maxHP"] != float64(8) || info["speed"] != float64(-30) || info["name"] != "Snail" || info["rate"] != 0.5 {
+		t.Errorf("Unexpected info values: %v", info)
+	}
+	if uol := img["link"].(map[string]interface{}); uol["uol"] != "stand/0" {
+		t.Errorf("Unexpected UOL: %v", uol)
+	}
+	if convex := img["foothold"].([]interface{}); len(convex) != 1 {
+		t.Errorf("Unexpected Convex2D: %v", convex)
+	}
+
+	frame := img["stand"].(map[string]interface{})["0"].(map[string]interface{})
+	if origin := frame["origin"].(map[string]interface{}); origin["x"] != float64(13) || origin["y"] != float64(27) {
+		t.Errorf("Unexpected origin: %v", origin)
+	}
+	canvas := frame["_canvas"].(map[string]interface{})
+	data, err := base64.StdEncoding.DecodeString(canvas["png"].(string))
+	if err != nil {
+		t.Fatalf("Invalid base64 PNG: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	if decoded.Bounds().Dx() != 2 || decoded.Bounds().Dy() != 1 {
+		t.Errorf("PNG bounds = %v, want 2x1", decoded.Bounds())
+	}
+
+	sound := doc["Sound.img"].(map[string]interface{})["bgm"].(map[string]interface{})["_sound"].(map[string]interface{})
+	if sound["length"] != float64(4) || sound["playtime"] != float64(1500) || sound["data"] != nil {
+		t.Errorf("Unexpected sound: %v", sound)
+	}
+}
+
+func TestJSONWriterKeysAndStrings(t *testing.T) {
+	var buf bytes.Buffer
+	w := newJSONWriter(&buf, false)
+	values := []string{"plain", "quote \" and \\", "line\nbreak\ttab\x01", "<html> & é", "sep\u2028", "bad\xffbyte"}
+	w.beginObject()
+	for _, value := range values {
+		w.key("k")
+		w.stringValue(value)
+	}
+	w.key("k_3")
+	w.intValue(1)
+	w.endObject()
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicate keys get suffixes that skip the keys already taken
+	want := `{"k":"plain","k_2":"quote \" and \\","k_3":"line\nbreak\ttab\u0001","k_4":"<html> & é","k_5":"sep\u2028","k_6":"bad\ufffdbyte","k_3_2":1}`
+	if buf.String() != want {
+		t.Errorf("Wrote %s, want %s", buf.String(), want)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(doc) != 7 || doc["k_4"] != "<html> & é" {
+		t.Errorf("Decoded %v", doc)
+	}
+}
+
+func TestExportNXJSONWithMediaFiles(t *testing.T) {
+	file := convertTestDirectory(t, wztest.BuildDirectory(), converter.Options{Mode: converter.Client})
+	mediaDir := t.TempDir()
+
+	var buf bytes.Buffer
+	e := &jsonExporter{opts: exportOptions{Bitmaps: mediaFile, Audio: mediaFile, MediaDir: mediaDir}, w: newJSONWriter(&buf, false)}
+	if err := e.writeNXNode(file.Root(), ""); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := e.w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Export is not valid JSON: %v\n%s", err, buf.String())
+	}
+
+	frame := doc["Mob"].(map[string]interface{})["100100.img"].(map[string]interface{})["stand"].(map[string]interface{})["0"].(map[string]interface{})
+	canvas := frame["_canvas"].(map[string]interface{})
+	if canvas["file"] != "Mob/100100.img/stand/0.png" {
+		t.Errorf("Unexpected bitmap file reference: %v", canvas)
+	}
+	if _, err := os.Stat(filepath.Join(mediaDir, "Mob", "100100.img", "stand", "0.png")); err != nil {
+		t.Errorf("Bitmap file was not written: %v", err)
+	}
+
+	sound := doc["Sound.img"].(map[string]interface{})["bgm"].(map[string]interface{})
+	if sound["file"] != "Sound.img/bgm.mp3" {
+		t.Errorf("Unexpected audio file reference: %v", sound)
+	}
+}
+
+func TestExportMediaStaysInMediaDirectory(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	dir.Directory("Mob").Name = ".."
+	mediaDir := filepath.Join(t.TempDir(), "media")
+
+	var buf bytes.Buffer
+	e := &jsonExporter{opts: exportOptions{Bitmaps: mediaFile, MediaDir: mediaDir}, w: newJSONWriter(&buf, false)}
+	if err := e.writeWZDirectory(dir, ""); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := e.w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"file":"_../100100.img/stand/0.png"`) {
+		t.Errorf("Unexpected bitmap file reference:\n%s", buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(mediaDir, "_..", "100100.img", "stand", "0.png")); err != nil {
+		t.Errorf("Bitmap file is not below the media directory: %v", err)
+	}
+}
+
+func TestExportXML(t *testing.T) {
+	img := wztest.BuildDirectory().Directory("Mob").Image("100100.img")
+
+	var buf bytes.Buffer
+	if err := exportXMLImage(img, &buf, exportOptions{Bitmaps: mediaBase64}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	out := buf.String()
+
+	want := []string{
+		xmlHeader,
+		`<imgdir name="100100.img">`,
+		`    <int name="maxHP" value="8"/>`,
+		`    <short name="speed" value="-30"/>`,
+		`    <string name="name" value="Snail"/>`,
+		`    <float name="rate" value="0.5"/>`,
+		`    <canvas name="0" width="2" height="1" basedata="`,
+		`      <vector name="origin" x="13" y="27"/>`,
+		`  <uol name="link" value="stand/0"/>`,
+		`  <extended name="foothold">`,
+		`    <vector name="0" x="-5" y="6"/>`,
+	}
+	for _, line := range want {
+		if !strings.Contains(out, line) {
+			t.Errorf("Output is missing %q:\n%s", line, out)
+		}
+	}
+
+	var doc struct{}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Errorf("Output is not well-formed XML: %v", err)
+	}
+
+	// A canvas without pixels is written without basedata, like in the
+	// JSON export, and does not stop the export
+	stand := img.Properties.Get("stand").Value.(*wz.WZProperty)
+	canvas := stand.Get("0").Value.(*wz.WZCanvas)
+	canvas.Width, canvas.Height = 0, 0
+	var failures []error
+	buf.Reset()
+	opts := exportOptions{Bitmaps: mediaBase64, Failed: func(err error) { failures = append(failures, err) }}
+	if err := exportXMLImage(img, &buf, opts); err != nil {
+		t.Fatalf("Export with an empty canvas failed: %v", err)
+	}
+	if len(failures) != 0 || !strings.Contains(buf.String(), `<canvas name="0" width="0" height="0">`) ||
+		!strings.Contains(buf.String(), `<extended name="foothold">`) {
+		t.Errorf("Failures %v, output:\n%s", failures, buf.String())
+	}
+}
+
+func TestExportXMLStaysInOutputDirectory(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	dir.Directory("Mob").Name = ".."
+	out := filepath.Join(t.TempDir(), "xml")
+
+	if err := exportXMLDirectory(dir, out, dir.Name, exportOptions{}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "Test.wz", "_..", "100100.img.xml")); err != nil {
+		t.Errorf("Image is not below the output directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "100100.img.xml")); err == nil {
+		t.Errorf("Image was written outside its directory")
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	var filter pathFilter
+	if err := filter.Set("Mob/*.img/stand"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	tests := []struct {
+		path         string
+		match, enter bool
+	}{
+		{"Mob", false, true},
+		{"Mob/100100.img", false, true},
+		{"Mob/100100.img/stand", true, true},
+		{"Mob/100100.img/stand/0", true, true},
+		{"Mob/100100.img/info", false, false},
+		{"Sound.img", false, false},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.path); got != tt.match {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.match)
+		}
+		if got := filter.Enter(tt.path); got != tt.enter {
+			t.Errorf("Enter(%q) = %v, want %v", tt.path, got, tt.enter)
+		}
+	}
+}
+
+func TestExtractImages(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client})
+
+	for _, source := range []string{"wz", "nx"} {
+		t.Run(source, func(t *testing.T) {
+			x := newExtractor(extractOptions{OutDir: t.TempDir(), Sidecar: true, Workers: 2})
+			if source == "wz" {
+				x.extractWZDirectory(dir, "Test")
+			} else {
+				x.extractNXNode(file.Root(), "Test")
+			}
+			x.wait()
+			if len(x.failures) > 0 || x.written != 1 {
+				t.Fatalf("Wrote %d image(s), failures: %v", x.written, x.failures)
+			}
+
+			f, err := os.Open(filepath.Join(x.opts.OutDir, "Test", "Mob", "100100.img", "stand", "0.png"))
+			if err != nil {
+				t.Fatalf("PNG was not written: %v", err)
+			}
+			defer f.Close()
+			img, err := png.Decode(f)
+			if err != nil {
+				t.Fatalf("Invalid PNG: %v", err)
+			}
+			if img.Bounds().Dx() != 2 || img.Bounds().Dy() != 1 {
+				t.Errorf("PNG bounds = %v, want 2x1", img.Bounds())
+			}
+
+			data, err := os.ReadFile(filepath.Join(x.opts.OutDir, "Test", "Mob", "100100.img", "stand", "0.json"))
+			if err != nil {
+				t.Fatalf("Sidecar was not written: %v", err)
+			}
+			var sidecar map[string]interface{}
+			if err := json.Unmarshal(data, &sidecar); err != nil {
+				t.Fatalf("Invalid sidecar: %v", err)
+			}
+			origin, _ := sidecar["origin"].(map[string]interface{})
+			if sidecar["width"] != float64(2) || sidecar["delay"] != float64(120) || origin["x"] != float64(13) {
+				t.Errorf("Unexpected sidecar: %s", data)
+			}
+		})
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	for name, want := range map[string]string{"0": "0", "..": "_..", ".": "_.", "": "_", `a\b`: "a_b"} {
+		if got := safeName(name); got != want {
+			t.Errorf("safeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+	dir := t.TempDir()
+	target, err := outputPath(dir, "Mob/../../x", ".png")
+	if err != nil {
+		t.Fatalf("outputPath failed: %v", err)
+	}
+	if want := filepath.Join(dir, "Mob", "_..", "_..", "x.png"); target != want {
+		t.Errorf("outputPath = %s, want %s", target, want)
+	}
+}
+
+func TestExtractStaysInOutputDirectory(t *testing.T) {
+	// A directory named ".." must not lead out of the output directory
+	dir := wztest.BuildDirectory()
+	dir.Directory("Mob").Name = ".."
+	parent := t.TempDir()
+	out := filepath.Join(parent, "out")
+
+	x := newExtractor(extractOptions{OutDir: out, Workers: 2})
+	x.extractWZDirectory(dir, "Test")
+	x.wait()
+	if len(x.failures) > 0 || x.written != 1 {
+		t.Fatalf("Wrote %d image(s), failures: %v", x.written, x.failures)
+	}
+	if _, err := os.Stat(filepath.Join(out, "Test", "_..", "100100.img", "stand", "0.png")); err != nil {
+		t.Errorf("PNG is not below the output directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "100100.img")); err == nil {
+		t.Errorf("PNG was written outside the output directory")
+	}
+}
+
+func TestPathFilterSharedByConvertAndExtract(t *testing.T) {
+	// One pattern selects the same nodes in convert --include and
+	// extract --path, from the WZ file and from its NX file
+	var filter pathFilter
+	if err := filter.Set("Test/Mob/*.img/stand"); err != nil {
+		t.Fatal(err)
+	}
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client, Filter: filter.filter})
+	img, ok := file.Root().Resolve("Mob/100100.img")
+	if !ok || len(img.Children()) != 1 || img.Children()[0].Name() != "stand" {
+		t.Fatalf("Converted image does not hold stand alone")
+	}
+
+	for _, source := range []string{"wz", "nx"} {
+		x := newExtractor(extractOptions{OutDir: t.TempDir(), Filter: filter, Workers: 2})
+		if source == "wz" {
+			x.extractWZDirectory(dir, "Test")
+		} else {
+			x.extractNXNode(file.Root(), "Test")
+		}
+		x.wait()
+		if len(x.failures) > 0 || x.written != 1 {
+			t.Fatalf("%s: wrote %d image(s), failures: %v", source, x.written, x.failures)
+		}
+		if _, err := os.Stat(filepath.Join(x.opts.OutDir, "Test", "Mob", "100100.img", "stand", "0.png")); err != nil {
+			t.Errorf("%s: PNG was not written: %v", source, err)
+		}
+	}
+}
+
+func TestExtractSounds(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	soundImg := dir.Image("Sound.img")
+	variant := wztest.AddVariant(soundImg.Properties, soundImg.WZSimpleNode, "click", 9, nil)
+	pcm := wz.NewWZSoundDX8("click", variant.WZSimpleNode)
+	pcm.Playtime = 10
+	pcm.FormatTag, pcm.Channels, pcm.SampleRate, pcm.BitsPerSample = wz.WaveFormatPCM, 1, 22050, 16
+	pcm.WaveFormat = []byte{1, 0, 1, 0, 0x22, 0x56, 0, 0, 0x44, 0xAC, 0, 0, 2, 0, 16, 0, 0, 0}
+	pcm.SoundData = []byte{1, 2, 3, 4, 5, 6}
+	variant.Value = pcm
+	// A sibling with the same name gets a file of its own
+	wztest.AddVariant(soundImg.Properties, soundImg.WZSimpleNode, "click", 9, pcm)
+
+	x := newExtractor(extractOptions{OutDir: t.TempDir(), Workers: 2})
+	x.sounds = true
+	x.extractWZDirectory(dir, "Test")
+	x.wait()
+	if len(x.failures) > 0 || x.written != 3 {
+		t.Fatalf("Wrote %d sound(s), failures: %v", x.written, x.failures)
+	}
+	if err := x.writeManifest(); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(x.opts.OutDir, "Test", "Sound.img", "bgm.mp3")); err != nil {
+		t.Errorf("MP3 was not written: %v", err)
+	}
+	wav, err := os.ReadFile(filepath.Join(x.opts.OutDir, "Test", "Sound.img", "click.wav"))
+	if err != nil {
+		t.Fatalf("WAV was not written: %v", err)
+	}
+	if string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" || int(binary.LittleEndian.Uint32(wav[4:])) != len(wav)-8 {
+		t.Errorf("Invalid RIFF header: % x", wav[:12])
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:]); rate != 22050 {
+		t.Errorf("Sample rate = %d, want 22050", rate)
+	}
+	if !bytes.HasSuffix(wav, pcm.SoundData) {
+		t.Errorf("WAV does not end with the sample data")
+	}
+
+	data, err := os.ReadFile(filepath.Join(x.opts.OutDir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Manifest was not written: %v", err)
+	}
+	var manifest []soundEntry
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest: %v", err)
+	}
+	if len(manifest) != 3 ||
+		manifest[0].Path != "Test/Sound.img/bgm" || manifest[0].Format != "mp3" || manifest[0].Playtime != 1500 ||
+		manifest[1].File != "Test/Sound.img/click.wav" || manifest[1].Format != "pcm" || manifest[1].Channels != 1 ||
+		manifest[2].Path != "Test/Sound.img/click" || manifest[2].File != "Test/Sound.img/click_2.wav" {
+		t.Errorf("Unexpected manifest: %s", data)
+	}
+}
+
+func TestConvertMetadata(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client, Metadata: true})
+
+	want := map[string]int64{
+		"Mob/100100.img/stand/0/_format":   2,
+		"Mob/100100.img/stand/0/_format2":  0,
+		"Mob/100100.img/stand/0/_maglevel": 0,
+		"Sound.img/bgm/_playtime":          1500,
+	}
+	for path, value := range want {
+		node, ok := file.Root().Resolve(path)
+		if !ok {
+			t.Errorf("%s is missing", path)
+			continue
+		}
+		if got, _ := node.Int(); got != value {
+			t.Errorf("%s = %d, want %d", path, got, value)
+		}
+	}
+	if node, _ := file.Root().Resolve("Mob/100100.img/stand/0"); node.Type() != nx.TypeBitmap {
+		t.Errorf("Canvas type = %s, want bitmap", node.Type())
+	}
+
+	cmp := &comparer{opts: compareOptions{Metadata: true}}
+	cmp.compareDirectory(dir, file.Root(), "")
+	if len(cmp.mismatches) != 0 {
+		t.Errorf("Unexpected mismatches %+v", cmp.mismatches)
+	}
+}
+
+func TestConvertServerDimensions(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	file := convertTestDirectory(t, dir, converter.Options{ServerDimensions: true})
+
+	canvas, _ := file.Root().Resolve("Mob/100100.img/stand/0")
+	if canvas.Type() != nx.TypeNone {
+		t.Errorf("Canvas type = %s, want none", canvas.Type())
+	}
+	width, _ := canvas.Child("_width")
+	height, _ := canvas.Child("_height")
+	if w, _ := width.Int(); w != 2 {
+		t.Errorf("_width = %d, want 2", w)
+	}
+	if h, _ := height.Int(); h != 1 {
+		t.Errorf("_height = %d, want 1", h)
+	}
+
+	cmp := &comparer{opts: compareOptions{Server: true}}
+	cmp.compareDirectory(dir, file.Root(), "")
+	if len(cmp.mismatches) != 0 {
+		t.Errorf("Unexpected mismatches %+v", cmp.mismatches)
+	}
+}
+
+func TestParseEmit(t *testing.T) {
+	outputs, err := parseEmit("client={name}.nx, server={name}.server.nx")
+	if err != nil {
+		t.Fatalf("parseEmit failed: %v", err)
+	}
+	want := []converter.Output{{Filename: "{name}.nx", Mode: converter.Client}, {Filename: "{name}.server.nx", Mode: converter.Server}}
+	if len(outputs) != 2 || outputs[0] != want[0] || outputs[1] != want[1] {
+		t.Errorf("parseEmit = %+v, want %+v", outputs, want)
+	}
+
+	for _, spec := range []string{"client", "full=a.nx", "server="} {
+		if _, err := parseEmit(spec); err == nil {
+			t.Errorf("parseEmit(%q) should fail", spec)
+		}
+	}
+}
+
+func TestConvertDeduplicatesMedia(t *testing.T) {
+	for _, dedup := range []bool{true, false} {
+		dir := wztest.BuildDirectory()
+
+		// A second frame with the same pixels, and a second copy of the sound
+		img := dir.Directory("Mob").Image("100100.img")
+		stand := img.Properties.Get("stand").Value.(*wz.WZProperty)
+		frame := stand.Get("0").Value.(*wz.WZCanvas)
+		variant := wztest.AddVariant(stand, img.WZSimpleNode, "1", 9, nil)
+		copied := wz.NewWZCanvas("1", variant.WZSimpleNode)
+		copied.Width, copied.Height, copied.Format1 = frame.Width, frame.Height, frame.Format1
+		copied.Data = append([]byte(nil), frame.Data...)
+		variant.Value = copied
+
+		soundImg := dir.Image("Sound.img")
+		bgm := soundImg.Properties.Get("bgm").Value.(*wz.WZSoundDX8)
+		variant = wztest.AddVariant(soundImg.Properties, soundImg.WZSimpleNode, "bgm2", 9, nil)
+		sound := wz.NewWZSoundDX8("bgm2", variant.WZSimpleNode)
+		sound.SoundData = append([]byte(nil), bgm.SoundData...)
+		variant.Value = sound
+
+		data, result := convertTestBytes(t, dir, converter.Options{Mode: converter.Client, NoDedup: !dedup})
+		file, err := nx.Load(data)
+		if err != nil {
+			t.Fatalf("Failed to load NX data: %v", err)
+		}
+
+		first, _ := file.Root().Resolve("Mob/100100.img/stand/0")
+		second, _ := file.Root().Resolve("Mob/100100.img/stand/1")
+		a, _ := first.Bitmap()
+		b, _ := second.Bitmap()
+
+		want := 2
+		if dedup {
+			want = 1
+			if a.ID != b.ID {
+				t.Errorf("Identical canvases have bitmap IDs %d and %d", a.ID, b.ID)
+			}
+			if stats := result.Dedup; stats.Bitmaps != 1 || stats.Audio != 1 || stats.BitmapBytes != 8 {
+				t.Errorf("Unexpected dedup stats %+v", stats)
+			}
+		}
+		if out := result.Outputs[0]; out.Bitmaps != want || out.Audio != want {
+			t.Errorf("dedup=%v: %d bitmaps and %d audio entries, want %d", dedup, out.Bitmaps, out.Audio, want)
+		}
+
+		cmp := &comparer{}
+		cmp.compareDirectory(dir, file.Root(), "")
+		if len(cmp.mismatches) != 0 {
+			t.Errorf("dedup=%v: unexpected mismatches %+v", dedup, cmp.mismatches)
+		}
+	}
+}
+
+func TestConvertSharesIdenticalSubtrees(t *testing.T) {
+	counts := make(map[bool]uint32)
+	for _, dedupNodes := range []bool{false, true} {
+		dir := wztest.BuildDirectory()
+
+		// info2 repeats info exactly, info3 differs in one value
+		img := dir.Directory("Mob").Image("100100.img")
+		for _, name := range []string{"info2", "info3"} {
+			info := wztest.NewProperty()
+			variant := wztest.AddVariant(img.Properties, img.WZSimpleNode, name, 9, info)
+			maxHP := int32(8)
+			if name == "info3" {
+				maxHP = 9
+			}
+			wztest.AddVariant(info, variant.WZSimpleNode, "maxHP", 3, maxHP)
+			wztest.AddVariant(info, variant.WZSimpleNode, "speed", 2, int16(-30))
+			wztest.AddVariant(info, variant.WZSimpleNode, "name", 8, "Snail")
+			wztest.AddVariant(info, variant.WZSimpleNode, "rate", 4, float32(0.5))
+		}
+
+		data, result := convertTestBytes(t, dir, converter.Options{Mode: converter.Client, DedupNodes: dedupNodes})
+		if report := nx.Verify(data); !report.Valid {
+			t.Fatalf("dedupNodes=%v: verify reported %+v", dedupNodes, report.Problems)
+		}
+		if out := result.Outputs[0]; out.TreeNodes-out.Nodes != map[bool]int{false: 0, true: 4}[dedupNodes] {
+			t.Errorf("dedupNodes=%v: result reports %d of %d nodes", dedupNodes, out.Nodes, out.TreeNodes)
+		}
+		file, err := nx.Load(data)
+		if err != nil {
+			t.Fatalf("Failed to load NX data: %v", err)
+		}
+		counts[dedupNodes] = file.Header.NodeCount
+
+		info, _ := file.Root().Resolve("Mob/100100.img/info")
+		info2, _ := file.Root().Resolve("Mob/100100.img/info2")
+		info3, _ := file.Root().Resolve("Mob/100100.img/info3")
+		shared := info.Children()[0].Index() == info2.Children()[0].Index()
+		if shared != dedupNodes || info.Children()[0].Index() == info3.Children()[0].Index() {
+			t.Errorf("dedupNodes=%v: child ranges start at %d, %d and %d", dedupNodes,
+				info.Children()[0].Index(), info2.Children()[0].Index(), info3.Children()[0].Index())
+		}
+
+		cmp := &comparer{}
+		cmp.compareDirectory(dir, file.Root(), "")
+		if len(cmp.mismatches) != 0 {
+			t.Errorf("dedupNodes=%v: unexpected mismatches %+v", dedupNodes, cmp.mismatches)
+		}
+	}
+
+	if counts[false]-counts[true] != 4 {
+		t.Errorf("Sharing saved %d nodes, want 4", counts[false]-counts[true])
+	}
+}
+
+func TestConvertKeepsDuplicateNamedSiblings(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	img := dir.Directory("Mob").Image("100100.img")
+	info := img.Properties.Get("info").Value.(*wz.WZProperty)
+	wztest.AddVariant(info, img.WZSimpleNode, "name", 8, "Red Snail")
+	if got := info.Get("name").Value; got != "Snail" {
+		t.Errorf("Get returned %v, want the first sibling", got)
+	}
+
+	file := convertTestDirectory(t, dir, converter.Options{Mode: converter.Client})
+	node, _ := file.Root().Resolve("Mob/100100.img/info")
+	var names []string
+	for _, child := range node.Children() {
+		if child.Name() == "name" {
+			name, _ := child.Str()
+			names = append(names, name)
+		}
+	}
+	if len(names) != 2 || names[0] != "Snail" || names[1] != "Red Snail" {
+		t.Errorf("Duplicate siblings converted to %q", names)
+	}
+
+	cmp := &comparer{}
+	cmp.compareDirectory(dir, file.Root(), "")
+	if len(cmp.mismatches) != 0 {
+		t.Errorf("Unexpected mismatches %+v", cmp.mismatches)
+	}
+}
+
+func TestJSONProgress(t *testing.T) {
+	var buf bytes.Buffer
+	reporter, err := newProgressReporter("json", &buf, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := converter.Options{Mode: converter.Client, Progress: reporter.report}
+	reporter.startInput("Test.wz", 0)
+	convertTestBytes(t, wztest.BuildDirectory(), opts)
+
+	var events []progressEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event progressEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Line %q is not an event: %v", line, err)
+		}
+		if event.Input != "Test.wz" || event.File != 1 || event.Files != 2 {
+			t.Errorf("Event %+v does not name input 1 of 2", event)
+		}
+		events = append(events, event)
+	}
+	if first := events[0]; first.Event != converter.EventStart || first.Stage != converter.StageTraverse {
+		t.Errorf("First event is %+v, want the start of traversal", first)
+	}
+	if last := events[len(events)-1]; last.Event != converter.EventEnd || last.Stage != converter.StageWrite || last.Done != last.Total {
+		t.Errorf("Last event is %+v, want the end of writing", last)
+	}
+
+	if _, err := newProgressReporter("fancy", &buf, 1, 0); err == nil {
+		t.Error("Unknown progress mode was accepted")
+	}
+}
+
+func TestFailureSummary(t *testing.T) {
+	var s failureSummary
+	ctx, cancel := context.WithCancel(context.Background())
+	s.add(ctx, "Ok.wz", &converter.Result{}, nil)
+	if s.failed() {
+		t.Fatal("A clean conversion was counted as failed")
+	}
+	s.add(ctx, "Map.wz", &converter.Result{Failures: []converter.Failure{{Path: "Map.wz/Map0/1.img", Err: errors.New("bad")}}}, nil)
+	s.add(ctx, "Bad.wz", nil, errors.New("not a PKG1/WZ file"))
+	cancel()
+	s.add(ctx, "Mob.wz", nil, context.Canceled)
+
+	var buf bytes.Buffer
+	s.report(slog.New(slog.NewJSONHandler(&buf, nil)), converter.BestEffort)
+	var last map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		last = nil
+		if err := json.Unmarshal([]byte(line), &last); err != nil {
+			t.Fatalf("Line %q is not a record: %v", line, err)
+		}
+		if last["level"] != "WARN" {
+			t.Errorf("Best effort logged %v", last)
+		}
+	}
+	if last["failed_inputs"] != 1.0 || last["failed_nodes"] != 1.0 {
+		t.Errorf("Summary is %v, want one failed input and node", last)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	dir := wztest.BuildDirectory()
+	report := inspectWZ("Test.wz", &wz.WZFile{Root: dir}, 2)
+	if report.Directories != 1 || report.Images != 2 {
+		t.Errorf("WZ counts: %d directories, %d images", report.Directories, report.Images)
+	}
+	var paths []string
+	for _, node := range report.Nodes {
+		paths = append(paths, node.Path+":"+node.Type)
+	}
+	if got := strings.Join(paths, " "); got != "Mob:directory Mob/100100.img:image Sound.img:image" {
+		t.Errorf("WZ nodes: %s", got)
+	}
+
+	data, _ := convertTestBytes(t, dir, converter.Options{Mode: converter.Client})
+	file, err := nx.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = inspectNX("Test.nx", file, 1)
+	if report.Header.BitmapCount != 1 || report.Header.AudioCount != 1 {
+		t.Errorf("NX header: %+v", report.Header)
+	}
+	if len(report.Nodes) != 2 || report.Nodes[0].Path != "Mob" || report.Nodes[0].Children != 1 {
+		t.Errorf("NX nodes: %+v", report.Nodes)
+	}
+
+	var buf bytes.Buffer
+	writeInspectText(&buf, report)
+	if !strings.Contains(buf.String(), "  Mob (none, 1 child)\n") {
+		t.Errorf("Text report:\n%s", buf.String())
+	}
+}
+
+func TestConvertFileOutputs(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(in, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(in, "sub", "Etc.wz"), wztest.EncodeDirectories("Item"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inputs := findInputs([]string{in}, nil, ".wz")
+	if len(inputs) != 1 || inputs[0].Rel != filepath.Join("sub", "Etc.wz") {
+		t.Fatalf("Found %+v", inputs)
+	}
+	filter, err := converter.NewPathFilter(nil, []string{"Etc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if excluded := findInputs([]string{in}, filter, ".wz"); len(excluded) != 0 {
+		t.Errorf("Found excluded inputs %+v", excluded)
+	}
+
+	opts := convertOptions{OutDir: out, NameTemplate: "{name}.{mode}.nx"}
+	want := filepath.Join(out, "sub", "Etc.server.nx")
+	ctx := context.Background()
+	if _, err := convertFile(ctx, inputs[0], opts); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("Output is not at %s: %v", want, err)
+	}
+
+	// Existing outputs fail the input unless they are skipped or replaced
+	if _, err := convertFile(ctx, inputs[0], opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Converting over an existing output returned %v", err)
+	}
+	if err := os.WriteFile(want, []byte("kept"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Existing = existingSkip
+	if _, err := convertFile(ctx, inputs[0], opts); err != nil {
+		t.Errorf("Skipping an existing output returned %v", err)
+	}
+	if data, _ := os.ReadFile(want); string(data) != "kept" {
+		t.Errorf("Skipped output was replaced")
+	}
+	opts.Existing = existingOverwrite
+	if _, err := convertFile(ctx, inputs[0], opts); err != nil {
+		t.Errorf("Overwriting an existing output returned %v", err)
+	}
+	if file, err := nx.Open(want); err != nil {
+		t.Errorf("Overwritten output is not an NX file: %v", err)
+	} else {
+		file.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ErwinsExpertise/go-wztonx-converter/nx"
+)
+
+// verifyResult is the machine-readable output of the verify command
+type verifyResult struct {
+	Valid   bool         `json:"valid"`
+	Reports []*nx.Report `json:"reports"`
+}
+
+// runVerify implements the verify command and returns the process exit code:
+// 0 when every file is valid, 1 when problems were found and 2 on usage errors
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go-wztonx-converter verify <files.nx>")
+		fmt.Fprintln(flags.Output(), "Structurally validates NX files and prints a JSON report.")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	result := verifyResult{Valid: true}
+	for _, filename := range flags.Args() {
+		report, err := nx.VerifyFile(filename)
+		if err != nil {
+			report = &nx.Report{
+				File:     filename,
+				Problems: []nx.Problem{{Check: "open", Message: err.Error()}},
+			}
+		}
+		result.Valid = result.Valid && report.Valid
+		result.Reports = append(result.Reports, report)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		return 2
+	}
+
+	if !result.Valid {
+		return 1
+	}
+	return 0
+}
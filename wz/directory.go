@@ -13,7 +13,7 @@ type WZDirectoryLoader struct {
 func (m *WZDirectoryLoader) DoWork(workRoutine int) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Printf("Error parsing directory %s: %v\n", m.Directory.GetPath(), r)
+			m.FileBlob.file.log().Error("parsing directory failed", "file", m.FileBlob.Name, "path", m.Directory.GetPath(), "error", r)
 		}
 	}()
 	m.Directory.Parse(m.FileBlob, m.Offset)
@@ -28,7 +28,7 @@ type WZImageLoader struct {
 func (m *WZImageLoader) DoWork(workRoutine int) {
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Printf("Error parsing image %s: %v\n", m.Image.GetPath(), r)
+			m.FileBlob.file.log().Error("parsing image failed", "file", m.FileBlob.Name, "path", m.Image.GetPath(), "error", r)
 		}
 	}()
 	m.Image.Parse(m.FileBlob, m.Offset)
@@ -37,21 +37,56 @@ func (m *WZImageLoader) DoWork(workRoutine int) {
 type WZDirectory struct {
 	*WZSimpleNode
 
-	Directories    map[string]*WZDirectory
-	Images         map[string]*WZImage
-	DirectoryOrder []string // Preserves insertion order for directories
-	ImageOrder     []string // Preserves insertion order for images
+	// Subdirectories and images in file order, including entries that
+	// share a name
+	Directories []*WZDirectory
+	Images      []*WZImage
+
+	directoryIndex map[string]int
+	imageIndex     map[string]int
 }
 
 func NewWZDirectory(name string, parent *WZSimpleNode) *WZDirectory {
 	node := new(WZDirectory)
 	node.WZSimpleNode = NewWZSimpleNode(name, parent)
-	node.Directories = make(map[string]*WZDirectory)
-	node.Images = make(map[string]*WZImage)
+	node.directoryIndex = make(map[string]int)
+	node.imageIndex = make(map[string]int)
 
 	return node
 }
 
+// AddDirectory appends a subdirectory
+func (m *WZDirectory) AddDirectory(dir *WZDirectory) {
+	if _, exists := m.directoryIndex[dir.Name]; !exists {
+		m.directoryIndex[dir.Name] = len(m.Directories)
+	}
+	m.Directories = append(m.Directories, dir)
+}
+
+// AddImage appends an image
+func (m *WZDirectory) AddImage(img *WZImage) {
+	if _, exists := m.imageIndex[img.Name]; !exists {
+		m.imageIndex[img.Name] = len(m.Images)
+	}
+	m.Images = append(m.Images, img)
+}
+
+// Directory returns the first subdirectory with the given name, or nil
+func (m *WZDirectory) Directory(name string) *WZDirectory {
+	if i, exists := m.directoryIndex[name]; exists {
+		return m.Directories[i]
+	}
+	return nil
+}
+
+// Image returns the first image with the given name, or nil
+func (m *WZDirectory) Image(name string) *WZImage {
+	if i, exists := m.imageIndex[name]; exists {
+		return m.Images[i]
+	}
+	return nil
+}
+
 func (m *WZDirectory) Parse(file *WZFileBlob, offset int64) {
 	file.seek(offset)
 
@@ -90,8 +125,7 @@ func (m *WZDirectory) Parse(file *WZFileBlob, offset int64) {
 		if elementType == 3 {
 
 			newDir := NewWZDirectory(name, m.WZSimpleNode)
-			m.Directories[name] = newDir
-			m.DirectoryOrder = append(m.DirectoryOrder, name) // Track insertion order
+			m.AddDirectory(newDir)
 			if true {
 				work := new(WZDirectoryLoader)
 				work.Directory = newDir
@@ -99,7 +133,7 @@ func (m *WZDirectory) Parse(file *WZFileBlob, offset int64) {
 				work.Offset = dataOffset
 
 				if err := file.workPool.PostWork("directory loader", work); err != nil {
-					fmt.Println("ERROR ", err)
+					file.file.log().Error("queueing directory failed", "file", file.Name, "path", newDir.GetPath(), "error", err)
 				}
 			} else {
 				newDir.Parse(file, dataOffset)
@@ -107,8 +141,7 @@ func (m *WZDirectory) Parse(file *WZFileBlob, offset int64) {
 
 		} else {
 			img := NewWZImage(name, m.WZSimpleNode)
-			m.Images[name] = img
-			m.ImageOrder = append(m.ImageOrder, name) // Track insertion order
+			m.AddImage(img)
 			if !file.file.LazyLoading {
 				if false {
 					// Goroutine spamming
@@ -122,7 +155,7 @@ func (m *WZDirectory) Parse(file *WZFileBlob, offset int64) {
 					work.Offset = dataOffset
 
 					if err := file.workPool.PostWork("image loader", work); err != nil {
-						fmt.Println("ERROR ", err)
+						file.file.log().Error("queueing image failed", "file", file.Name, "path", img.GetPath(), "error", err)
 					}
 				} else {
 					// Sync loading
@@ -3,9 +3,15 @@ package wz
 import (
 	//"bytes"
 	"strings"
+	"sync"
 )
 
+// Encryption decrypts data with the XOR key of a WZ variant. The key is
+// expanded on demand, so an Encryption may be shared by blobs parsed in
+// parallel.
 type Encryption struct {
+	mu sync.Mutex
+
 	encryptedStrings []string
 	aesIV            []byte
 	aesKey           []byte
@@ -32,6 +38,8 @@ func (m *Encryption) IsEncrypted(uol string) bool {
 }
 
 func (m *Encryption) TransformBuffer(buffer []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.tryExpandXorKey(len(buffer))
 	for i := 0; i < len(buffer); i++ {
 		buffer[i] ^= m.xorKey[i]
@@ -57,9 +65,11 @@ func (m *Encryption) setWZVariant(variant byte) {
 	m.tryExpandXorKey(400) // Pre-built a WZ key for 400 characters. Should be enough for most of the simple data/strings.
 }
 
+// tryExpandXorKey makes the XOR key at least length bytes long. The caller
+// must hold mu, except while the Encryption is being set up.
 func (m *Encryption) tryExpandXorKey(length int) {
 	// Check if we already have enough data
-	if len(m.xorKey) < length {
+	if len(m.xorKey) >= length {
 		return
 	}
 
@@ -1,10 +1,13 @@
 package wz
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/edsrzf/mmap-go"
 	"github.com/goinggo/workpool"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -19,6 +22,7 @@ type WZFile struct {
 	mainBlob    *WZFileBlob
 
 	workPool *workpool.WorkPool
+	logger   *slog.Logger
 
 	FileDescription string
 	Debug           bool
@@ -38,24 +42,67 @@ func NewFile(filename string) (*WZFile, error) {
 		return nil, err
 	}
 
-	wz := new(WZFile)
+	wz := newFile(filename)
 	wz.filemap = filemap
+	wz.mainBlob = NewWZFileBlob(wz.filemap, nil, wz)
+
+	return wz, nil
+}
+
+// NewFileFromReader reads a WZ file of size bytes from r instead of
+// mapping a file from disk. name is used as the file name. The parser
+// issues many small reads at scattered offsets, so slow sources should be
+// buffered or cached by the caller. r must stay readable until the file is
+// closed.
+func NewFileFromReader(r io.ReaderAt, size int64, name string) *WZFile {
+	wz := newFile(name)
+	wz.mainBlob = NewWZFileBlobAt(r, size, nil, wz)
+	return wz
+}
+
+func newFile(filename string) *WZFile {
+	wz := new(WZFile)
 	wz.Debug = false
 	wz.Filename = filename
 	wz.workPool = workpool.New(runtime.NumCPU()*2, 7000)
-	wz.mainBlob = NewWZFileBlob(wz.filemap, nil, wz)
 	wz.LazyLoading = true
+	return wz
+}
 
-	return wz, nil
+// SetLogger sends the debug records and parse errors of the file to
+// logger. Debug records are only produced when logger is enabled for the
+// debug level. It must be called before Parse.
+func (m *WZFile) SetLogger(logger *slog.Logger) {
+	m.logger = logger
+	m.Debug = logger != nil && logger.Enabled(context.Background(), slog.LevelDebug)
+	m.mainBlob.Debug = m.Debug
+}
+
+// SetEncryption selects the WZ variant whose key decrypts encrypted data
+// in the file, such as the wave formats of some sounds. Without it, each
+// known key is tried. It must be called before Parse.
+func (m *WZFile) SetEncryption(variant byte) {
+	m.mainBlob.encryption = NewEncryption(variant)
+}
+
+// log returns the logger of the file, or the default logger if none is set
+func (m *WZFile) log() *slog.Logger {
+	if m.logger != nil {
+		return m.logger
+	}
+	return slog.Default()
 }
 
 func (m *WZFile) debug(args ...interface{}) {
 	if m.Debug {
-		fmt.Println(fmt.Sprint("[WZFile: ", m.Filename, "] ", fmt.Sprint(args...)))
+		m.log().Debug(fmt.Sprint(args...), "file", m.Filename)
 	}
 }
 
 func (m *WZFile) Close() error {
+	if m.filemap == nil {
+		return nil
+	}
 	return m.filemap.Unmap()
 }
 
@@ -161,7 +208,7 @@ func Fetch(node interface{}, elem string) interface{} {
 			case string:
 				node = val
 			default:
-				println("WARN: Could not unpack variant with type ", variant.Type)
+				slog.Warn("could not unpack variant", "path", variant.GetPath(), "type", variant.Type)
 			}
 		}
 	}
@@ -171,31 +218,34 @@ func Fetch(node interface{}, elem string) interface{} {
 
 func GetChildNodes(node interface{}) map[string]interface{} {
 	elements := make(map[string]interface{})
+	// The first child wins when siblings share a name
+	add := func(name string, elem interface{}) {
+		if _, exists := elements[name]; !exists {
+			elements[name] = elem
+		}
+	}
+	addProperties := func(prop *WZProperty) {
+		if prop != nil {
+			for _, elem := range prop.Entries {
+				add(elem.Name, elem)
+			}
+		}
+	}
 	switch n := node.(type) {
 	case *WZDirectory:
-		for name, elem := range n.Directories {
-			elements[name] = elem
+		for _, elem := range n.Directories {
+			add(elem.Name, elem)
 		}
-		for name, elem := range n.Images {
-			elements[name] = elem
+		for _, elem := range n.Images {
+			add(elem.Name, elem)
 		}
 	case *WZProperty:
-		for name, elem := range n.Properties {
-			elements[name] = elem
-		}
+		addProperties(n)
 	case *WZImage:
 		n.StartParse()
-		if n.Properties != nil {
-			for name, elem := range n.Properties.Properties {
-				elements[name] = elem
-			}
-		}
+		addProperties(n.Properties)
 	case *WZCanvas:
-		if n.Properties != nil {
-			for name, elem := range n.Properties.Properties {
-				elements[name] = elem
-			}
-		}
+		addProperties(n.Properties)
 	case *WZVariant:
 		elements = GetChildNodes(n.Value)
 
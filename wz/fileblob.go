@@ -6,45 +6,84 @@ import (
 	"errors"
 	"fmt"
 	"github.com/goinggo/workpool"
+	"io"
 	"strconv"
 )
 
 type WZFileBlob struct {
-	reader        *bytes.Reader
+	reader        io.ReadSeeker
 	encryption    *Encryption
 	file          *WZFile
 	contentsStart int32
 	Debug         bool
 	Name          string
-	debug         func(...interface{})
-	data          []byte
+
+	// The blob reads either data, or size bytes of source when the file
+	// is not held in memory
+	data   []byte
+	source io.ReaderAt
+	size   int64
 
 	workPool *workpool.WorkPool
 }
 
 func NewWZFileBlob(data []byte, encryption *Encryption, file *WZFile) *WZFileBlob {
+	m := newWZFileBlob(encryption, file)
+	m.data = data
+	m.reader = bytes.NewReader(m.data)
+	return m
+}
+
+// NewWZFileBlobAt creates a blob that reads size bytes from source. Copies
+// read source concurrently through ReadAt.
+func NewWZFileBlobAt(source io.ReaderAt, size int64, encryption *Encryption, file *WZFile) *WZFileBlob {
+	m := newWZFileBlob(encryption, file)
+	m.source = source
+	m.size = size
+	m.reader = io.NewSectionReader(source, 0, size)
+	return m
+}
+
+func newWZFileBlob(encryption *Encryption, file *WZFile) *WZFileBlob {
 	m := new(WZFileBlob)
 	m.contentsStart = 0
 	m.encryption = encryption
 	m.Debug = file.Debug
 	m.Name = file.Filename
-	m.debug = file.debug
 	m.file = file
-	m.data = data
-	m.reader = bytes.NewReader(m.data)
 	m.workPool = file.workPool
 
 	return m
 }
 
+// debug logs a debug record with the current offset
+func (m *WZFileBlob) debug(args ...interface{}) {
+	m.file.log().Debug(fmt.Sprint(args...), "file", m.Name, "offset", m.pos())
+}
+
+// debugAt logs a debug record about the node at path
+func (m *WZFileBlob) debugAt(path string, args ...interface{}) {
+	m.file.log().Debug(fmt.Sprint(args...), "file", m.Name, "path", path, "offset", m.pos())
+}
+
 func (m *WZFileBlob) Copy() *WZFileBlob {
-	obj := NewWZFileBlob(m.data, m.encryption, m.file)
+	var obj *WZFileBlob
+	if m.source != nil {
+		obj = NewWZFileBlobAt(m.source, m.size, m.encryption, m.file)
+	} else {
+		obj = NewWZFileBlob(m.data, m.encryption, m.file)
+	}
 	obj.contentsStart = m.contentsStart
 	return obj
 }
 
 func (m *WZFileBlob) CopySliced(start int) *WZFileBlob {
-	obj := NewWZFileBlob(m.data[start:], m.encryption, m.file)
+	var obj *WZFileBlob
+	if m.source != nil {
+		obj = NewWZFileBlobAt(io.NewSectionReader(m.source, int64(start), m.size-int64(start)), m.size-int64(start), m.encryption, m.file)
+	} else {
+		obj = NewWZFileBlob(m.data[start:], m.encryption, m.file)
+	}
 	obj.contentsStart = m.contentsStart
 	return obj
 }
@@ -122,7 +161,7 @@ func (m *WZFileBlob) readFloat64() (out float64) {
 func (m *WZFileBlob) readBytes(size int32) []byte {
 	var out []byte = make([]byte, size)
 
-	amount, err := m.reader.Read(out)
+	amount, err := io.ReadFull(m.reader, out)
 	if err != nil {
 		panic(err)
 	}
@@ -138,11 +177,7 @@ func (m *WZFileBlob) readBytes(size int32) []byte {
 func (m *WZFileBlob) readASCIIZString() string {
 	ret := make([]byte, 0)
 	for {
-		b, err := m.reader.ReadByte()
-		if err != nil {
-			panic(err)
-		}
-
+		b := m.readByte()
 		if b == 0 {
 			break
 		}
@@ -63,3 +63,14 @@ func (m *WZImage) ParseWithCopy() {
 		m.parseFuncInfo()
 	}
 }
+
+// Unload drops the parsed properties of a lazily loaded image so that
+// walking a large file does not keep every image in memory. It does nothing
+// for images that cannot be parsed again.
+func (m *WZImage) Unload() {
+	if m.parseFile == nil {
+		return
+	}
+	m.Properties = nil
+	m.Parsed = false
+}
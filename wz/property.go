@@ -2,9 +2,38 @@ package wz
 
 import "strconv"
 
+// WZProperty is a property list. Entries keeps every child in file order,
+// including siblings that share a name.
 type WZProperty struct {
-	Properties map[string]*WZVariant
-	Order      []string // Preserves insertion order
+	Entries []*WZVariant
+
+	index map[string]int // name -> position of the first entry with that name
+}
+
+func NewWZProperty(capacity int) *WZProperty {
+	return &WZProperty{
+		Entries: make([]*WZVariant, 0, capacity),
+		index:   make(map[string]int, capacity),
+	}
+}
+
+// Add appends a child to the list
+func (m *WZProperty) Add(variant *WZVariant) {
+	if m.index == nil {
+		m.index = make(map[string]int)
+	}
+	if _, exists := m.index[variant.Name]; !exists {
+		m.index[variant.Name] = len(m.Entries)
+	}
+	m.Entries = append(m.Entries, variant)
+}
+
+// Get returns the first child with the given name, or nil
+func (m *WZProperty) Get(name string) *WZVariant {
+	if i, exists := m.index[name]; exists {
+		return m.Entries[i]
+	}
+	return nil
 }
 
 func ParseProperty(parent *WZSimpleNode, file *WZFileBlob, offset int64) *WZProperty {
@@ -25,10 +54,7 @@ func ParseProperty(parent *WZSimpleNode, file *WZFileBlob, offset int64) *WZProp
 		panic("Invalid property count: " + strconv.Itoa(propcount))
 	}
 
-	result := &WZProperty{
-		Properties: make(map[string]*WZVariant),
-		Order:      make([]string, 0, propcount),
-	}
+	result := NewWZProperty(propcount)
 
 	for i := 0; i < propcount; i++ {
 		name := file.readWZObjectUOL(parent.GetPath(), offset)
@@ -37,8 +63,7 @@ func ParseProperty(parent *WZSimpleNode, file *WZFileBlob, offset int64) *WZProp
 		}
 		variant := NewWZVariant(name, parent)
 		variant.Parse(file, offset)
-		result.Properties[name] = variant
-		result.Order = append(result.Order, name) // Track insertion order
+		result.Add(variant)
 	}
 
 	return result
@@ -1,7 +1,5 @@
 package wz
 
-import "fmt"
-
 type WZSimpleNode struct {
 	*WithParent
 	Name string
@@ -37,8 +35,5 @@ func (m *WZSimpleNode) debug(file *WZFileBlob, args ...interface{}) {
 	if !file.Debug {
 		return
 	}
-	tmp := make([]interface{}, 1)
-	tmp[0] = fmt.Sprint("[ ", file.pos(), " : ", m.GetPath(), "]")
-	tmp = append(tmp, args...)
-	file.debug(tmp...)
+	file.debugAt(m.GetPath(), args...)
 }
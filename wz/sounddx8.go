@@ -1,11 +1,64 @@
 package wz
 
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// GUID is a Windows GUID as stored on disk
+type GUID [16]byte
+
+func (g GUID) String() string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(g[0:]),
+		binary.LittleEndian.Uint16(g[4:]),
+		binary.LittleEndian.Uint16(g[6:]),
+		g[8:10], g[10:])
+}
+
+// DirectShow media type GUIDs used in Sound_DX8 headers
+var (
+	MediaTypeStream        = GUID{0x83, 0xEB, 0x36, 0xE4, 0x4F, 0x52, 0xCE, 0x11, 0x9F, 0x53, 0x00, 0x20, 0xAF, 0x0B, 0xA7, 0x70}
+	MediaSubtypeMPEG1Audio = GUID{0x87, 0xEB, 0x36, 0xE4, 0x4F, 0x52, 0xCE, 0x11, 0x9F, 0x53, 0x00, 0x20, 0xAF, 0x0B, 0xA7, 0x70}
+	MediaSubtypeWAVE       = GUID{0x8B, 0xEB, 0x36, 0xE4, 0x4F, 0x52, 0xCE, 0x11, 0x9F, 0x53, 0x00, 0x20, 0xAF, 0x0B, 0xA7, 0x70}
+	FormatTypeWaveFormatEx = GUID{0x81, 0x9F, 0x58, 0x05, 0x56, 0xC3, 0xCE, 0x11, 0xBF, 0x01, 0x00, 0xAA, 0x00, 0x55, 0x59, 0x5A}
+	FormatTypeNone         = GUID{0xD6, 0x17, 0x64, 0x0F, 0x18, 0xC3, 0xD0, 0x11, 0xA4, 0x3F, 0x00, 0xA0, 0xC9, 0x22, 0x31, 0x96}
+)
+
+// soundHeaderWaveLenOffset is the position of the wave format length in a
+// Sound_DX8 header: a byte, the major type and subtype GUIDs, two bytes and
+// the format type GUID come first
+const soundHeaderWaveLenOffset = 1 + 16 + 16 + 2 + 16
+
+// Wave format tags
+const (
+	WaveFormatPCM = 0x0001
+	WaveFormatMP3 = 0x0055
+)
+
 type WZSoundDX8 struct {
 	*WZImageObject
 
 	Playtime   int32
 	HeaderData []byte
 	SoundData  []byte
+
+	// Media type of the payload, from HeaderData
+	MajorType  GUID
+	Subtype    GUID
+	FormatType GUID
+
+	// WAVEFORMATEX fields, from HeaderData. They are zero when the header
+	// carries no wave format.
+	FormatTag      uint16
+	Channels       uint16
+	SampleRate     uint32
+	AvgBytesPerSec uint32
+	BlockAlign     uint16
+	BitsPerSample  uint16
+
+	// WaveFormat is the decrypted WAVEFORMATEX, including extra bytes
+	WaveFormat []byte
 }
 
 func NewWZSoundDX8(name string, parent *WZSimpleNode) *WZSoundDX8 {
@@ -25,7 +78,96 @@ func (m *WZSoundDX8) Parse(file *WZFileBlob, offset int64) {
 	dataLen := file.readWZInt()
 	m.Playtime = file.readWZInt()
 
-	m.HeaderData = file.readBytes(82)
+	// The header is a byte, three GUIDs and the length prefixed wave
+	// format, which is 82 bytes for MP3 but differs between versions
+	var waveLen uint8
+	file.peekFor(func() {
+		file.skip(soundHeaderWaveLenOffset)
+		waveLen = file.readByte()
+	})
+	m.HeaderData = file.readBytes(soundHeaderWaveLenOffset + 1 + int32(waveLen))
+	m.parseHeader(file.encryption)
 
 	m.SoundData = file.readBytes(dataLen)
 }
+
+// knownEncryptions are tried on an encrypted wave format when the file has
+// no encryption set
+var knownEncryptions = []*Encryption{
+	NewEncryption(VariantGMS),
+	NewEncryption(VariantSEA),
+	NewEncryption(0),
+}
+
+// parseHeader fills the media type and wave format fields from HeaderData.
+// Some versions encrypt the wave format; it is decrypted when its size
+// does not match its cbSize field, with encryption or, if that is nil,
+// with the first known key that yields a valid wave format.
+func (m *WZSoundDX8) parseHeader(encryption *Encryption) {
+	if len(m.HeaderData) <= soundHeaderWaveLenOffset {
+		return
+	}
+	copy(m.MajorType[:], m.HeaderData[1:17])
+	copy(m.Subtype[:], m.HeaderData[17:33])
+	copy(m.FormatType[:], m.HeaderData[35:51])
+
+	wave := m.HeaderData[soundHeaderWaveLenOffset+1:]
+	if n := int(m.HeaderData[soundHeaderWaveLenOffset]); n < len(wave) {
+		wave = wave[:n]
+	}
+	if len(wave) < 16 {
+		return
+	}
+
+	wave = append([]byte(nil), wave...)
+	if !validWaveFormat(wave) {
+		wave = decryptWaveFormat(wave, encryption)
+		if wave == nil {
+			return
+		}
+	}
+
+	m.WaveFormat = wave
+	m.FormatTag = binary.LittleEndian.Uint16(wave[0:])
+	m.Channels = binary.LittleEndian.Uint16(wave[2:])
+	m.SampleRate = binary.LittleEndian.Uint32(wave[4:])
+	m.AvgBytesPerSec = binary.LittleEndian.Uint32(wave[8:])
+	m.BlockAlign = binary.LittleEndian.Uint16(wave[12:])
+	m.BitsPerSample = binary.LittleEndian.Uint16(wave[14:])
+}
+
+// decryptWaveFormat decrypts an encrypted wave format, returning nil when
+// no key yields a valid one
+func decryptWaveFormat(wave []byte, encryption *Encryption) []byte {
+	candidates := knownEncryptions
+	if encryption != nil {
+		candidates = []*Encryption{encryption}
+	}
+	for _, candidate := range candidates {
+		decrypted := append([]byte(nil), wave...)
+		candidate.TransformBuffer(decrypted)
+		if validWaveFormat(decrypted) {
+			return decrypted
+		}
+	}
+	return nil
+}
+
+// validWaveFormat checks that a WAVEFORMATEX is exactly as long as its
+// cbSize field says. A bare 16 byte WAVEFORMAT has no cbSize.
+func validWaveFormat(wave []byte) bool {
+	if len(wave) == 16 {
+		return true
+	}
+	return len(wave) >= 18 && int(binary.LittleEndian.Uint16(wave[16:]))+18 == len(wave)
+}
+
+// IsMP3 reports whether the payload is MPEG audio
+func (m *WZSoundDX8) IsMP3() bool {
+	return m.FormatTag == WaveFormatMP3 || m.Subtype == MediaSubtypeMPEG1Audio
+}
+
+// IsPCM reports whether the payload is uncompressed PCM samples
+func (m *WZSoundDX8) IsPCM() bool {
+	return m.FormatTag == WaveFormatPCM
+}
@@ -0,0 +1,113 @@
+package wz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// soundHeader builds a Sound_DX8 header around a wave format
+func soundHeader(subtype GUID, wave []byte) []byte {
+	header := []byte{0x02}
+	header = append(header, MediaTypeStream[:]...)
+	header = append(header, subtype[:]...)
+	header = append(header, 0x00, 0x01)
+	header = append(header, FormatTypeWaveFormatEx[:]...)
+	header = append(header, byte(len(wave)))
+	return append(header, wave...)
+}
+
+// waveFormatEx builds a WAVEFORMATEX with extra bytes
+func waveFormatEx(tag, channels uint16, rate uint32, bits uint16, extra []byte) []byte {
+	blockAlign := channels * bits / 8
+	wave := binary.LittleEndian.AppendUint16(nil, tag)
+	wave = binary.LittleEndian.AppendUint16(wave, channels)
+	wave = binary.LittleEndian.AppendUint32(wave, rate)
+	wave = binary.LittleEndian.AppendUint32(wave, rate*uint32(blockAlign))
+	wave = binary.LittleEndian.AppendUint16(wave, blockAlign)
+	wave = binary.LittleEndian.AppendUint16(wave, bits)
+	wave = binary.LittleEndian.AppendUint16(wave, uint16(len(extra)))
+	return append(wave, extra...)
+}
+
+// parseSound parses a serialized Sound_DX8 followed by a marker byte and
+// checks that the parser stopped right before the marker
+func parseSound(t *testing.T, header []byte, encryption *Encryption) *WZSoundDX8 {
+	t.Helper()
+	data := []byte{0x00, 4, 100} // version, data length, playtime
+	data = append(data, header...)
+	data = append(data, 0xDE, 0xAD, 0xBE, 0xEF, 0x7F)
+
+	blob := NewWZFileBlob(data, encryption, &WZFile{})
+	sound := NewWZSoundDX8("bgm", nil)
+	sound.Parse(blob, 0)
+
+	if marker := blob.readByte(); marker != 0x7F {
+		t.Fatalf("Parser desynced: read %#x after the sound", marker)
+	}
+	if sound.Playtime != 100 || !bytes.Equal(sound.SoundData, []byte{0xDE, 0xAD, 0xBE, 0xEF}) {
+		t.Errorf("Playtime = %d, SoundData = % x", sound.Playtime, sound.SoundData)
+	}
+	if !bytes.Equal(sound.HeaderData, header) {
+		t.Errorf("HeaderData = % x, want % x", sound.HeaderData, header)
+	}
+	return sound
+}
+
+func TestSoundDX8ParsesMP3Header(t *testing.T) {
+	// MPEGLAYER3WAVEFORMAT: 18 bytes plus 12 extra bytes, 82 bytes in total
+	header := soundHeader(MediaSubtypeWAVE, waveFormatEx(WaveFormatMP3, 2, 44100, 0, make([]byte, 12)))
+	if len(header) != 82 {
+		t.Fatalf("Test header is %d bytes, want 82", len(header))
+	}
+
+	sound := parseSound(t, header, nil)
+	if !sound.IsMP3() || sound.IsPCM() {
+		t.Errorf("IsMP3 = %v, IsPCM = %v", sound.IsMP3(), sound.IsPCM())
+	}
+	if sound.Channels != 2 || sound.SampleRate != 44100 {
+		t.Errorf("Channels = %d, SampleRate = %d", sound.Channels, sound.SampleRate)
+	}
+	if sound.MajorType != MediaTypeStream || sound.Subtype != MediaSubtypeWAVE || sound.FormatType != FormatTypeWaveFormatEx {
+		t.Errorf("Unexpected media type %s / %s / %s", sound.MajorType, sound.Subtype, sound.FormatType)
+	}
+	if got := MediaTypeStream.String(); got != "E436EB83-524F-11CE-9F53-0020AF0BA770" {
+		t.Errorf("GUID string = %s", got)
+	}
+}
+
+func TestSoundDX8ParsesPCMHeader(t *testing.T) {
+	// A plain WAVEFORMATEX makes the header 70 bytes
+	sound := parseSound(t, soundHeader(MediaSubtypeWAVE, waveFormatEx(WaveFormatPCM, 1, 22050, 16, nil)), nil)
+	if !sound.IsPCM() || sound.IsMP3() {
+		t.Errorf("IsMP3 = %v, IsPCM = %v", sound.IsMP3(), sound.IsPCM())
+	}
+	if sound.Channels != 1 || sound.SampleRate != 22050 || sound.BitsPerSample != 16 || sound.BlockAlign != 2 {
+		t.Errorf("Unexpected format: %+v", sound.WaveFormat)
+	}
+	if len(sound.WaveFormat) != 18 {
+		t.Errorf("WaveFormat is %d bytes, want 18", len(sound.WaveFormat))
+	}
+}
+
+func TestSoundDX8DecryptsWaveFormat(t *testing.T) {
+	wave := waveFormatEx(WaveFormatPCM, 2, 44100, 16, nil)
+	encrypted := append([]byte(nil), wave...)
+	NewEncryption(VariantGMS).TransformBuffer(encrypted)
+
+	sound := parseSound(t, soundHeader(MediaSubtypeWAVE, encrypted), NewEncryption(VariantGMS))
+	if !bytes.Equal(sound.WaveFormat, wave) || sound.SampleRate != 44100 {
+		t.Errorf("WaveFormat = % x, want % x", sound.WaveFormat, wave)
+	}
+}
+
+func TestSoundDX8TriesKnownKeys(t *testing.T) {
+	wave := waveFormatEx(WaveFormatPCM, 1, 22050, 16, nil)
+	encrypted := append([]byte(nil), wave...)
+	NewEncryption(VariantSEA).TransformBuffer(encrypted)
+
+	sound := parseSound(t, soundHeader(MediaSubtypeWAVE, encrypted), nil)
+	if !bytes.Equal(sound.WaveFormat, wave) || sound.SampleRate != 22050 {
+		t.Errorf("WaveFormat = % x, want % x", sound.WaveFormat, wave)
+	}
+}
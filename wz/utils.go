@@ -29,7 +29,9 @@ func expandXorKey(currentIV, aesKey, currentXorKey []byte, neededLength int) (ne
 		finalLength += 1
 	}
 	finalLength *= 16
-	finalLength -= len(currentXorKey)
+	if finalLength <= len(currentXorKey) {
+		return currentIV, currentXorKey
+	}
 
 	nextBlock := make([]byte, finalLength-len(currentXorKey))
 
@@ -2,6 +2,7 @@ package wz
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 )
 
@@ -61,3 +62,24 @@ func TestHashCalculator(t *testing.T) {
 		}
 	}
 }
+
+func TestTransformBufferExpandsXorKey(t *testing.T) {
+	_, want := expandXorKey(GMS_WZ_IV, WZ_AES_KEY, []byte{}, 1000)
+
+	// The key is pre-built for 400 bytes, so longer buffers expand it
+	// while other goroutines transform with it
+	encryption := NewEncryption(VariantGMS)
+	var wg sync.WaitGroup
+	for _, size := range []int{500, 100, 1000, 417} {
+		wg.Add(1)
+		go func(size int) {
+			defer wg.Done()
+			buffer := make([]byte, size)
+			encryption.TransformBuffer(buffer)
+			if !bytes.Equal(buffer, want[:size]) {
+				t.Errorf("TransformBuffer of %d bytes used the wrong key", size)
+			}
+		}(size)
+	}
+	wg.Wait()
+}